@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	addressFamilyIPv4 = "ipv4"
+	addressFamilyIPv6 = "ipv6"
+	addressFamilyAny  = "any"
+)
+
+// addressFamily is the IP version preferred for containers that have both IPv4 and IPv6
+// addresses. Addresses of the other version are used if a container has none of it.
+var addressFamily = addressFamilyIPv4
+
+// checkAddressFamily returns an error if family is not an address family preference
+func checkAddressFamily(family string) error {
+	switch family {
+	case addressFamilyIPv4, addressFamilyIPv6, addressFamilyAny:
+		return nil
+	}
+
+	return fmt.Errorf("Invalid address family %q, expected %s, %s or %s", family, addressFamilyIPv4, addressFamilyIPv6, addressFamilyAny)
+}
+
+// selectAddress returns the address a container is reached at from the addresses its hostname
+// resolves to, preferring addresses of the given family. Link-local IPv6 addresses are only
+// used when no other address is available, and only with a zone naming the interface they
+// are reached through.
+// An error is returned if none of the addresses can be used.
+func selectAddress(addresses []string, family string) (string, error) {
+	var selected string
+	best := 0
+
+	for _, address := range addresses {
+		rank := addressRank(address, family)
+		if rank > best {
+			selected = address
+			best = rank
+		}
+	}
+
+	if selected == "" {
+		return "", fmt.Errorf("No usable address in %s", strings.Join(addresses, ", "))
+	}
+
+	return selected, nil
+}
+
+// addressRank returns how suitable an address is for reaching a container, from 0 for an
+// address that cannot be used to 3 for a routable address of the preferred family
+func addressRank(address, family string) int {
+	host := address
+	zone := ""
+	if i := strings.LastIndex(address, "%"); i >= 0 {
+		host = address[:i]
+		zone = address[i+1:]
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return 0
+	}
+
+	isIPv4 := ip.To4() != nil
+
+	if !isIPv4 && ip.IsLinkLocalUnicast() {
+		if zone == "" {
+			return 0
+		}
+
+		return 1
+	}
+
+	if family == addressFamilyAny || isIPv4 == (family == addressFamilyIPv4) {
+		return 3
+	}
+
+	return 2
+}
+
+// urlHost escapes the zone of an IPv6 address in a host or host:port so that it can be written
+// in a URL
+func urlHost(host string) string {
+	return strings.Replace(host, "%", "%25", 1)
+}
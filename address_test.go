@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSelectAddress(t *testing.T) {
+	dualStack := []string{"fd00::4", "172.23.0.4"}
+
+	tests := []struct {
+		addresses []string
+		family    string
+		expected  string
+	}{
+		{[]string{"172.23.0.4"}, addressFamilyIPv4, "172.23.0.4"},
+		{[]string{"172.23.0.4"}, addressFamilyIPv6, "172.23.0.4"},
+		{[]string{"fd00::4"}, addressFamilyIPv4, "fd00::4"},
+		{dualStack, addressFamilyIPv4, "172.23.0.4"},
+		{dualStack, addressFamilyIPv6, "fd00::4"},
+		{dualStack, addressFamilyAny, "fd00::4"},
+		{[]string{"fe80::42:acff:fe17:4", "fd00::4"}, addressFamilyIPv6, "fd00::4"},
+		{[]string{"fe80::42:acff:fe17:4%eth0", "172.23.0.4"}, addressFamilyIPv6, "172.23.0.4"},
+		{[]string{"fe80::42:acff:fe17:4%eth0"}, addressFamilyIPv4, "fe80::42:acff:fe17:4%eth0"},
+	}
+
+	for _, test := range tests {
+		address, err := selectAddress(test.addresses, test.family)
+		if err != nil {
+			t.Errorf("Unexpected error for %v, got %s", test.addresses, err)
+			continue
+		}
+
+		if address != test.expected {
+			t.Errorf("Unexpected address for %v with %s, want %s got %s", test.addresses, test.family, test.expected, address)
+		}
+	}
+}
+
+func TestSelectAddressErrors(t *testing.T) {
+	tests := [][]string{
+		nil,
+		{"fe80::42:acff:fe17:4"},
+		{"container.local"},
+	}
+
+	for _, addresses := range tests {
+		_, err := selectAddress(addresses, addressFamilyIPv4)
+		if err == nil {
+			t.Errorf("Expected error for %v", addresses)
+		}
+	}
+}
+
+func TestCheckAddressFamily(t *testing.T) {
+	for _, family := range []string{addressFamilyIPv4, addressFamilyIPv6, addressFamilyAny} {
+		err := checkAddressFamily(family)
+		if err != nil {
+			t.Errorf("Unexpected error for %s, got %s", family, err)
+		}
+	}
+
+	err := checkAddressFamily("ipv5")
+	if err == nil {
+		t.Error("Expected error for ipv5")
+	}
+}
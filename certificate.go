@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"path/filepath"
@@ -83,11 +85,34 @@ func FindCertificateForHost(hostname string, fs afero.Fs) (*Certificate, error)
 	return nil, fmt.Errorf("Unable to find certificate for %s", hostname)
 }
 
+// findCertificate returns the Certificate for the root domain of the given hostname
+func findCertificate(hostname string, fs afero.Fs) (*Certificate, error) {
+	rootHostname, err := getRootDomain(hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	return FindCertificateForHost(rootHostname, fs)
+}
+
 // FullPath returns the full path of a certificate file
 func (c *Certificate) FullPath() string {
 	return filepath.Join(CertificatePath, c.Name)
 }
 
+// Digest returns the SHA-256 digest of the certificate file, which changes when the
+// certificate is replaced
+func (c *Certificate) Digest() (string, error) {
+	contents, err := afero.ReadFile(c.Fs, c.FullPath())
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(contents)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
 func (c *Certificate) belongsToHost(host string) bool {
 	baseCertName := strings.Split(c.Name, ".pem")[0]
 
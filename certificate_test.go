@@ -84,3 +84,25 @@ func TestFullPath(t *testing.T) {
 		t.Errorf("Unexpected certificate path, got %s want %s", cert.FullPath(), CertificatePath)
 	}
 }
+
+func TestDigest(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	cert := NewCertificate("mysite.pem", fs)
+	afero.WriteFile(fs, cert.FullPath(), []byte("original"), 0644)
+
+	original, err := cert.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	afero.WriteFile(fs, cert.FullPath(), []byte("renewed"), 0644)
+
+	renewed, err := cert.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if original == renewed {
+		t.Errorf("Expected digest to change with the certificate, got %s", renewed)
+	}
+}
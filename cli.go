@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// cliCommand is a subcommand of the hera command line
+type cliCommand struct {
+	Name        string
+	Usage       string
+	Description string
+	Run         func(args []string, stdout, stderr io.Writer) int
+}
+
+// cliCommands returns every subcommand in the order they are listed in the usage
+func cliCommands() []*cliCommand {
+	return []*cliCommand{
+		{"daemon", "daemon", "Run Hera and manage tunnels for containers (default)", runDaemonCommand},
+		{"version", "version", "Print the version of Hera", runVersionCommand},
+		{"list", "list", "List the tunnels of the running daemon", runListCommand},
+		{"status", "status <hostname>", "Show the status of a tunnel", runStatusCommand},
+		{"inspect", "inspect <hostname>", "Show the status, config and service files of a tunnel", runInspectCommand},
+		{"logs", "logs [-n lines] <hostname>", "Print the cloudflared log of a tunnel", runLogsCommand},
+		{"restart", "restart <hostname>", "Restart a tunnel", runTunnelOperation("restart", "Restarted")},
+		{"stop", "stop <hostname>", "Stop a tunnel", runTunnelOperation("stop", "Stopped")},
+		{"start", "start <hostname>", "Start a stopped tunnel", runTunnelOperation("start", "Started")},
+		{"reconcile", "reconcile", "Start tunnels for running containers and stop the rest", runReconcileCommand},
+		{"reload-certificates", "reload-certificates", "Restart the tunnels whose certificates have changed", runReloadCertificatesCommand},
+		{"validate", "validate", "Check the config, certificates and container labels", runValidateCommand},
+		{"doctor", "doctor", "Check the environment Hera runs in for common problems", runDoctorCommand},
+		{"plan", "plan", "Show what Hera would change for the running containers", runPlanCommand},
+	}
+}
+
+// RunCLI runs the subcommand named by the first argument and returns its exit code.
+// Hera runs as a daemon when no subcommand is given.
+func RunCLI(args []string, stdout, stderr io.Writer) int {
+	name := "daemon"
+	if len(args) > 0 {
+		name = args[0]
+		args = args[1:]
+	}
+
+	if name == "help" || name == "-h" || name == "--help" {
+		printUsage(stdout)
+		return 0
+	}
+
+	for _, command := range cliCommands() {
+		if command.Name != name {
+			continue
+		}
+
+		if command.Name != "daemon" {
+			InitCommandLogger()
+		}
+
+		return command.Run(args, stdout, stderr)
+	}
+
+	fmt.Fprintf(stderr, "Unknown command %q\n\n", name)
+	printUsage(stderr)
+
+	return 2
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: hera <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, command := range cliCommands() {
+		fmt.Fprintf(tw, "  %s\t%s\n", command.Usage, command.Description)
+	}
+	tw.Flush()
+}
+
+// parseHostnameArgs parses the flags of a command that takes a single hostname argument.
+// It returns false if the arguments are invalid, after printing the usage.
+func parseHostnameArgs(flags *flag.FlagSet, args []string, stderr io.Writer) (string, bool) {
+	flags.SetOutput(stderr)
+
+	err := flags.Parse(args)
+	if err != nil {
+		return "", false
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(stderr, "Usage: hera %s <hostname>\n", flags.Name())
+		return "", false
+	}
+
+	return flags.Arg(0), true
+}
+
+// controlClient returns a client for the control socket of the running daemon
+func controlClient() *ControlClient {
+	config, err := LoadConfig(afero.NewOsFs())
+	if err != nil {
+		config = DefaultConfig()
+	}
+
+	return NewControlClient(config.ControlSocket)
+}
+
+func runDaemonCommand(args []string, stdout, stderr io.Writer) int {
+	return runDaemon()
+}
+
+func runVersionCommand(args []string, stdout, stderr io.Writer) int {
+	fmt.Fprintf(stdout, "Hera v%s\n", CurrentVersion)
+	return 0
+}
+
+func runPlanCommand(args []string, stdout, stderr io.Writer) int {
+	config, err := LoadConfig(afero.NewOsFs())
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to load config: %s\n", err)
+		return 1
+	}
+
+	applyTunnelSettings(config)
+
+	return RunPlan(stdout)
+}
+
+func runListCommand(args []string, stdout, stderr io.Writer) int {
+	var statuses []*TunnelStatus
+
+	err := controlClient().GetJSON("/tunnels", &statuses)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOSTNAME\tCONTAINER\tORIGIN\tSTATE\tCONNECTION\tERROR")
+
+	for _, status := range statuses {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			status.Hostname,
+			shortID(status.ContainerID),
+			status.Origin,
+			status.State,
+			status.Connection.State,
+			status.LastError,
+		)
+	}
+	tw.Flush()
+
+	return 0
+}
+
+func runStatusCommand(args []string, stdout, stderr io.Writer) int {
+	hostname, ok := parseHostnameArgs(flag.NewFlagSet("status", flag.ContinueOnError), args, stderr)
+	if !ok {
+		return 2
+	}
+
+	var status TunnelStatus
+
+	err := controlClient().GetJSON("/tunnels/"+url.PathEscape(hostname), &status)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	printStatus(stdout, &status)
+
+	return 0
+}
+
+func runLogsCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("logs", flag.ContinueOnError)
+	lines := flags.Int("n", defaultLogLines, "number of lines to print")
+
+	hostname, ok := parseHostnameArgs(flags, args, stderr)
+	if !ok {
+		return 2
+	}
+
+	path := fmt.Sprintf("/tunnels/%s/logs?lines=%s", url.PathEscape(hostname), strconv.Itoa(*lines))
+
+	body, err := controlClient().Get(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	stdout.Write(body)
+
+	return 0
+}
+
+func runInspectCommand(args []string, stdout, stderr io.Writer) int {
+	hostname, ok := parseHostnameArgs(flag.NewFlagSet("inspect", flag.ContinueOnError), args, stderr)
+	if !ok {
+		return 2
+	}
+
+	var inspection TunnelInspection
+
+	err := controlClient().GetJSON(fmt.Sprintf("/tunnels/%s/inspect", url.PathEscape(hostname)), &inspection)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	printStatus(stdout, inspection.Status)
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	if inspection.MetricsAddr != "" {
+		fmt.Fprintf(tw, "Metrics:\t%s\n", inspection.MetricsAddr)
+	}
+	fmt.Fprintf(tw, "Log file:\t%s\n", inspection.LogFile)
+	tw.Flush()
+
+	fmt.Fprintf(stdout, "\n%s:\n%s\n", inspection.ConfigFile, inspection.Config)
+	fmt.Fprintf(stdout, "\n%s:\n%s\n", inspection.RunFile, inspection.Run)
+
+	return 0
+}
+
+// runTunnelOperation returns a command that posts an action for a single tunnel to the daemon
+func runTunnelOperation(action, done string) func(args []string, stdout, stderr io.Writer) int {
+	return func(args []string, stdout, stderr io.Writer) int {
+		hostname, ok := parseHostnameArgs(flag.NewFlagSet(action, flag.ContinueOnError), args, stderr)
+		if !ok {
+			return 2
+		}
+
+		_, err := controlClient().Post(fmt.Sprintf("/tunnels/%s/%s", url.PathEscape(hostname), action))
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+
+		fmt.Fprintf(stdout, "%s tunnel %s\n", done, hostname)
+
+		return 0
+	}
+}
+
+func runReconcileCommand(args []string, stdout, stderr io.Writer) int {
+	body, err := controlClient().Post("/reconcile")
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	var report ReviveReport
+
+	err = json.Unmarshal(body, &report)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	printHostnames(stdout, "Adopted", report.Adopted)
+	printHostnames(stdout, "Restarted", report.Restarted)
+	printHostnames(stdout, "Started", report.Started)
+	printHostnames(stdout, "Waiting", report.Waiting)
+	printHostnames(stdout, "Stopped", report.Stopped)
+	printHostnames(stdout, "Failed", report.Failed)
+
+	if len(report.Failed) > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+func runReloadCertificatesCommand(args []string, stdout, stderr io.Writer) int {
+	body, err := controlClient().Post("/certificates/reload")
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	var report CertificateReport
+
+	err = json.Unmarshal(body, &report)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	printHostnames(stdout, "Restarted", report.Restarted)
+	printHostnames(stdout, "Unchanged", report.Unchanged)
+	printHostnames(stdout, "Failed", report.Failed)
+
+	if len(report.Failed) > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+func runDoctorCommand(args []string, stdout, stderr io.Writer) int {
+	return RunDoctor(stdout)
+}
+
+func runValidateCommand(args []string, stdout, stderr io.Writer) int {
+	return RunValidate(stdout)
+}
+
+// printStatus writes the status of a tunnel in a human readable form
+func printStatus(w io.Writer, status *TunnelStatus) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Hostname:\t%s\n", status.Hostname)
+	fmt.Fprintf(tw, "Container:\t%s\n", shortID(status.ContainerID))
+	fmt.Fprintf(tw, "Origin:\t%s\n", status.Origin)
+	fmt.Fprintf(tw, "Certificate:\t%s\n", status.Certificate)
+
+	state := status.State
+	if service := status.Service; service != nil && service.Up {
+		state = fmt.Sprintf("%s (pid %d, up %s)", state, service.PID, service.Duration)
+	} else if service != nil && service.ExitCode >= 0 {
+		state = fmt.Sprintf("%s (exit code %d)", state, service.ExitCode)
+	}
+	fmt.Fprintf(tw, "State:\t%s\n", state)
+
+	connection := status.Connection.State
+	if status.Connection.State == connectionConnected {
+		connection = fmt.Sprintf("%s (%d connections)", connection, status.Connection.Connections)
+	}
+	if !status.Connection.UpdatedAt.IsZero() {
+		connection = fmt.Sprintf("%s since %s", connection, status.Connection.UpdatedAt.Format(time.RFC3339))
+	}
+	fmt.Fprintf(tw, "Connection:\t%s\n", connection)
+
+	if status.Connection.LastError != "" {
+		fmt.Fprintf(tw, "Connection error:\t%s\n", status.Connection.LastError)
+	}
+
+	fmt.Fprintf(tw, "Restarts:\t%d\n", status.CrashLoop.Restarts)
+
+	if status.CrashLoop.Failed {
+		fmt.Fprintf(tw, "Crash loop:\t%s, retrying at %s\n", status.CrashLoop.Reason, status.CrashLoop.RetryAt.Format(time.RFC3339))
+	}
+
+	if status.LastError != "" {
+		fmt.Fprintf(tw, "Last error:\t%s\n", status.LastError)
+	}
+
+	tw.Flush()
+}
+
+// printHostnames writes a labelled list of hostnames, or nothing if the list is empty
+func printHostnames(w io.Writer, label string, hostnames []string) {
+	if len(hostnames) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "%s: %s\n", label, strings.Join(hostnames, ", "))
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+
+	return id
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunCLIVersion(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := RunCLI([]string{"version"}, &stdout, &stderr)
+	if code != 0 {
+		t.Errorf("Unexpected exit code %d", code)
+	}
+
+	if stdout.String() != "Hera v"+CurrentVersion+"\n" {
+		t.Errorf("Unexpected output, got %s", stdout.String())
+	}
+}
+
+func TestRunCLIUnknownCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := RunCLI([]string{"unknown"}, &stdout, &stderr)
+	if code != 2 {
+		t.Errorf("Unexpected exit code %d", code)
+	}
+
+	if !strings.Contains(stderr.String(), "Usage: hera <command>") {
+		t.Errorf("Expected usage, got %s", stderr.String())
+	}
+}
+
+func TestRunCLIMissingHostname(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := RunCLI([]string{"status"}, &stdout, &stderr)
+	if code != 2 {
+		t.Errorf("Unexpected exit code %d", code)
+	}
+}
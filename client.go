@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/events"
@@ -32,9 +34,15 @@ func NewClient() (*Client, error) {
 	return client, nil
 }
 
-// Events returns a channel of Docker events
-func (c *Client) Events() (<-chan events.Message, <-chan error) {
-	return c.DockerClient.Events(context.Background(), types.EventsOptions{})
+// Events returns a channel of Docker events, starting with past events from since unless it is
+// zero. The stream is closed when the context is done.
+func (c *Client) Events(ctx context.Context, since time.Time) (<-chan events.Message, <-chan error) {
+	options := types.EventsOptions{}
+	if !since.IsZero() {
+		options.Since = fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())
+	}
+
+	return c.DockerClient.Events(ctx, options)
 }
 
 // ListContainers returns a collection of Docker containers
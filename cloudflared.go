@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	heraCloudflaredPrefix = "hera.cloudflared."
+)
+
+var (
+	// cloudflaredDefaults are the cloudflared settings written to the config file of every
+	// tunnel, unless a container sets them itself with hera.cloudflared.* labels
+	cloudflaredDefaults map[string]string
+
+	// reservedSettings are the cloudflared settings Hera manages itself, which would break a
+	// tunnel or hand its certificate to another process if they were overridden
+	reservedSettings = map[string]string{
+		"hostname":             "",
+		"url":                  "",
+		"unix-socket":          "",
+		"hello-world":          "",
+		"bastion":              heraBastion,
+		"logfile":              "",
+		"log-directory":        "",
+		"origincert":           "",
+		"credentials-file":     "",
+		"credentials-contents": "",
+		"token":                "",
+		"tunnel":               "",
+		"config":               "",
+		"pidfile":              "",
+		"metrics":              "",
+		"no-autoupdate":        "",
+		"no-tls-verify":        heraOriginPrefix + originNoTLSVerify,
+		"origin-server-name":   heraOriginPrefix + originServerName,
+		"origin-ca-pool":       heraOriginPrefix + originCAPool,
+		"http-host-header":     heraOriginPrefix + originHostHeader,
+	}
+
+	settingKeyPattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	plainSettingPattern = regexp.MustCompile(`^[A-Za-z0-9/.][A-Za-z0-9_./@+=:,-]*$`)
+)
+
+// parseCloudflaredSettings returns the cloudflared settings in the hera.cloudflared.* labels of
+// a container, or nil if it has none.
+// An error is returned if a setting is reserved or invalid.
+func parseCloudflaredSettings(labels map[string]string) (map[string]string, error) {
+	var settings map[string]string
+
+	for name, value := range labels {
+		if !strings.HasPrefix(name, heraCloudflaredPrefix) {
+			continue
+		}
+
+		if settings == nil {
+			settings = make(map[string]string)
+		}
+
+		settings[strings.TrimPrefix(name, heraCloudflaredPrefix)] = value
+	}
+
+	err := checkCloudflaredSettings(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// checkCloudflaredSettings returns an error if a setting is reserved by Hera, is not a
+// cloudflared option name or has a value that does not fit on a single line
+func checkCloudflaredSettings(settings map[string]string) error {
+	for _, key := range settingKeys(settings) {
+		value := settings[key]
+
+		if !settingKeyPattern.MatchString(key) {
+			return fmt.Errorf("Invalid cloudflared setting %q", key)
+		}
+
+		if label, ok := reservedSettings[key]; ok {
+			if label != "" {
+				return fmt.Errorf("The cloudflared setting %s is managed by Hera, use the %s label instead", key, label)
+			}
+
+			return fmt.Errorf("The cloudflared setting %s is managed by Hera and cannot be overridden", key)
+		}
+
+		if value == "" || strings.IndexFunc(value, isControl) >= 0 {
+			return fmt.Errorf("Invalid value for cloudflared setting %s: %q", key, value)
+		}
+	}
+
+	return nil
+}
+
+// cloudflaredEntries returns the cloudflared settings of a tunnel config file, with the settings
+// of the tunnel taking precedence over cloudflaredDefaults
+func cloudflaredEntries(settings map[string]string) []configEntry {
+	merged := make(map[string]string)
+	for key, value := range cloudflaredDefaults {
+		merged[key] = value
+	}
+	for key, value := range settings {
+		merged[key] = value
+	}
+
+	var entries []configEntry
+	for _, key := range settingKeys(merged) {
+		entries = append(entries, configEntry{key, merged[key]})
+	}
+
+	return entries
+}
+
+// settingValue returns a value as it is written in a tunnel config file, quoting values that
+// would not be read back as plain strings, such as bracketed IPv6 addresses or values with
+// line breaks
+func settingValue(value string) string {
+	if plainSettingPattern.MatchString(value) {
+		return value
+	}
+
+	return strconv.Quote(value)
+}
+
+func settingKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
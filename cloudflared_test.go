@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCloudflaredSettings(t *testing.T) {
+	labels := map[string]string{
+		heraHostname:                      "site.tld",
+		"hera.cloudflared.ha-connections": "2",
+		"hera.cloudflared.proxy-address":  "http://proxy:3128",
+	}
+
+	settings, err := parseCloudflaredSettings(labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(settings) != 2 || settings["ha-connections"] != "2" || settings["proxy-address"] != "http://proxy:3128" {
+		t.Errorf("Unexpected settings, got %v", settings)
+	}
+
+	settings, err = parseCloudflaredSettings(map[string]string{heraHostname: "site.tld"})
+	if settings != nil || err != nil {
+		t.Errorf("Expected no settings, got %v and %v", settings, err)
+	}
+}
+
+func TestParseCloudflaredSettingsErrors(t *testing.T) {
+	tests := []map[string]string{
+		{"hera.cloudflared.origincert": "/tmp/cert.pem"},
+		{"hera.cloudflared.url": "http://other:80"},
+		{"hera.cloudflared.no-tls-verify": "true"},
+		{"hera.cloudflared.Retries": "3"},
+		{"hera.cloudflared.retries": ""},
+		{"hera.cloudflared.tag": "env=prod\norigincert: /tmp/cert.pem"},
+	}
+
+	for _, labels := range tests {
+		_, err := parseCloudflaredSettings(labels)
+		if err == nil {
+			t.Errorf("Expected error for labels %v", labels)
+		}
+	}
+}
+
+func TestCloudflaredConfig(t *testing.T) {
+	cloudflaredDefaults = map[string]string{"retries": "5", "loglevel": "debug"}
+	defer func() { cloudflaredDefaults = nil }()
+
+	tunnel := newTunnel()
+	tunnel.Config.Settings = map[string]string{"retries": "10", "tag": "team=web ops"}
+
+	config := tunnel.renderConfig()
+	expected := "loglevel: debug\nretries: 10\ntag: \"team=web ops\"\nlogfile: "
+	if !strings.Contains(config, expected) {
+		t.Errorf("Unexpected config, got %s", config)
+	}
+}
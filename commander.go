@@ -1,18 +1,105 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"os/exec"
+	"strings"
+	"time"
 )
 
+const (
+	defaultCommandTimeout = 10 * time.Second
+)
+
+// commandTimeouts holds the timeouts for commands that are expected to take longer than
+// defaultCommandTimeout
+var commandTimeouts = map[string]time.Duration{
+	"s6-svwait": 30 * time.Second,
+}
+
+// commandTimeout returns the timeout for the command with the given name
+func commandTimeout(name string) time.Duration {
+	timeout, ok := commandTimeouts[name]
+	if !ok {
+		return defaultCommandTimeout
+	}
+
+	return timeout
+}
+
 // Commander represents an interface for exec commands
 type Commander interface {
-	Run(name string, arg ...string) ([]byte, error)
+	Run(ctx context.Context, name string, arg ...string) ([]byte, error)
 }
 
 type Command struct{}
 
-// Run executes a command returns the output
-func (c Command) Run(name string, arg ...string) ([]byte, error) {
-	out, err := exec.Command(name, arg...).Output()
-	return out, err
+// CommandError is returned when a command fails to run or exits with a non-zero status
+type CommandError struct {
+	Name     string
+	Args     []string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+// CommandLine returns the full command line of the failed command
+func (e *CommandError) CommandLine() string {
+	return strings.Join(append([]string{e.Name}, e.Args...), " ")
+}
+
+func (e *CommandError) Error() string {
+	message := fmt.Sprintf("%s failed", e.CommandLine())
+
+	if e.ExitCode >= 0 {
+		message = fmt.Sprintf("%s with exit code %d", message, e.ExitCode)
+	} else {
+		message = fmt.Sprintf("%s: %s", message, e.Err)
+	}
+
+	if e.Stderr != "" {
+		message = fmt.Sprintf("%s: %s", message, e.Stderr)
+	}
+
+	return message
+}
+
+// Run executes a command and returns its output. The command is killed if it has not finished
+// by its timeout or when the context is done.
+// A *CommandError is returned if the command fails.
+func (c Command) Run(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout(name))
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err == nil {
+		return stdout.Bytes(), nil
+	}
+
+	cmdErr := &CommandError{
+		Name:     name,
+		Args:     arg,
+		ExitCode: -1,
+		Stderr:   strings.TrimSpace(stderr.String()),
+		Err:      err,
+	}
+
+	if ctx.Err() != nil {
+		cmdErr.Err = ctx.Err()
+	} else if exitErr, ok := err.(*exec.ExitError); ok {
+		cmdErr.ExitCode = exitErr.ExitCode()
+	}
+
+	metrics.CommandFailures.Inc(name)
+	tunnelLog.Errorf("Command failed: %s", cmdErr)
+
+	return stdout.Bytes(), cmdErr
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandRun(t *testing.T) {
+	out, err := Command{}.Run(context.Background(), "sh", "-c", "echo out")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "out\n" {
+		t.Errorf("Unexpected output, got %q", out)
+	}
+}
+
+func TestCommandRunError(t *testing.T) {
+	_, err := Command{}.Run(context.Background(), "sh", "-c", "echo failure >&2; exit 3")
+
+	cmdErr, ok := err.(*CommandError)
+	if !ok {
+		t.Fatalf("Expected CommandError, got %v", err)
+	}
+
+	if cmdErr.ExitCode != 3 || cmdErr.Stderr != "failure" {
+		t.Errorf("Unexpected error, got %+v", cmdErr)
+	}
+
+	if !strings.HasPrefix(cmdErr.Error(), "sh -c echo failure >&2; exit 3 failed with exit code 3") {
+		t.Errorf("Unexpected error message, got %s", cmdErr.Error())
+	}
+}
+
+func TestCommandRunTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err := Command{}.Run(ctx, "sleep", "5")
+
+	cmdErr, ok := err.(*CommandError)
+	if !ok {
+		t.Fatalf("Expected CommandError, got %v", err)
+	}
+
+	if cmdErr.Err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", cmdErr.Err)
+	}
+
+	if time.Since(started) > 2*time.Second {
+		t.Error("Expected command to be killed")
+	}
+}
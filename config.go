@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	ConfigPath = "/etc/hera/config.json"
+)
+
+// Config holds the settings for a running Hera instance. Settings are read from an optional
+// JSON file and can be overridden with environment variables.
+type Config struct {
+	StatusAddr         string `json:"status_addr"`
+	MetricsAddr        string `json:"metrics_addr"`
+	TunnelMetricsPorts string `json:"tunnel_metrics_ports"`
+
+	CrashLoopThreshold  int      `json:"crash_loop_threshold"`
+	CrashLoopWindow     Duration `json:"crash_loop_window"`
+	CrashLoopBackoff    Duration `json:"crash_loop_backoff"`
+	CrashLoopMaxBackoff Duration `json:"crash_loop_max_backoff"`
+
+	StopDelay Duration `json:"stop_delay"`
+
+	StopTunnelsOnExit bool   `json:"stop_tunnels_on_exit"`
+	StatePath         string `json:"state_path"`
+	ControlSocket     string `json:"control_socket"`
+
+	LogFormat string            `json:"log_format"`
+	LogLevel  string            `json:"log_level"`
+	LogLevels map[string]string `json:"log_levels"`
+	LogRedact bool              `json:"log_redact"`
+
+	LogMaxSize  int      `json:"log_max_size"`
+	LogMaxAge   Duration `json:"log_max_age"`
+	LogMaxFiles int      `json:"log_max_files"`
+	LogCompress bool     `json:"log_compress"`
+
+	SyslogAddr           string `json:"syslog_addr"`
+	SyslogFacility       string `json:"syslog_facility"`
+	SyslogForwardTunnels bool   `json:"syslog_forward_tunnels"`
+
+	Webhooks       []*Webhook `json:"webhooks"`
+	WebhookRetries int        `json:"webhook_retries"`
+	WebhookBackoff Duration   `json:"webhook_backoff"`
+
+	MaintenanceAddr  string `json:"maintenance_addr"`
+	MaintenancePages string `json:"maintenance_pages"`
+
+	OriginCAPath  string `json:"origin_ca_path"`
+	AddressFamily string `json:"address_family"`
+
+	Cloudflared map[string]string `json:"cloudflared"`
+}
+
+// Duration is a time.Duration read from a string such as "30s" or "5m"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return fmt.Errorf("Invalid duration %s", data)
+	}
+
+	d.Duration, err = time.ParseDuration(value)
+
+	return err
+}
+
+// MarshalJSON formats a duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// LogOptions returns the logging options of the config
+func (c *Config) LogOptions() *LogOptions {
+	options := &LogOptions{
+		Format:   c.LogFormat,
+		Level:    c.LogLevel,
+		Levels:   c.LogLevels,
+		Redact:   c.LogRedact,
+		Rotation: c.LogRotation(),
+
+		SyslogAddr:     c.SyslogAddr,
+		SyslogFacility: c.SyslogFacility,
+	}
+
+	return options
+}
+
+// LogRotation returns the policy for rotating log files, or nil if rotation is disabled
+func (c *Config) LogRotation() *RotationPolicy {
+	if c.LogMaxSize <= 0 && c.LogMaxAge.Duration <= 0 {
+		return nil
+	}
+
+	policy := &RotationPolicy{
+		MaxSize:  int64(c.LogMaxSize) * 1024 * 1024,
+		MaxAge:   c.LogMaxAge.Duration,
+		MaxFiles: c.LogMaxFiles,
+		Compress: c.LogCompress,
+	}
+
+	return policy
+}
+
+// DefaultConfig returns the Config used when no settings are given
+func DefaultConfig() *Config {
+	config := &Config{
+		CrashLoopThreshold:  5,
+		CrashLoopWindow:     Duration{time.Minute},
+		CrashLoopBackoff:    Duration{30 * time.Second},
+		CrashLoopMaxBackoff: Duration{10 * time.Minute},
+		StatePath:           "/var/lib/hera/state.json",
+		ControlSocket:       "/var/run/hera/hera.sock",
+		LogFormat:           logFormatText,
+		LogLevel:            "info",
+		LogRedact:           true,
+		LogMaxSize:          10,
+		LogMaxFiles:         5,
+		LogCompress:         true,
+		SyslogFacility:      "daemon",
+		WebhookRetries:      3,
+		WebhookBackoff:      Duration{time.Second},
+		MaintenancePages:    "/etc/hera/maintenance",
+		OriginCAPath:        defaultOriginCAPath,
+		AddressFamily:       addressFamilyIPv4,
+	}
+
+	return config
+}
+
+// LoadConfig returns the Config read from the config file and the environment.
+// An error is returned if the config file exists but cannot be parsed.
+func LoadConfig(fs afero.Fs) (*Config, error) {
+	config := DefaultConfig()
+
+	path := ConfigPath
+	if value, ok := os.LookupEnv("HERA_CONFIG"); ok {
+		path = value
+	}
+
+	exists, err := afero.Exists(fs, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		contents, err := afero.ReadFile(fs, path)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(contents, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	envString(&config.StatusAddr, "HERA_STATUS_ADDR")
+	envString(&config.MetricsAddr, "HERA_METRICS_ADDR")
+	envString(&config.TunnelMetricsPorts, "HERA_TUNNEL_METRICS_PORTS")
+	envString(&config.StatePath, "HERA_STATE_PATH")
+	envString(&config.ControlSocket, "HERA_CONTROL_SOCKET")
+	envString(&config.LogFormat, "HERA_LOG_FORMAT")
+	envString(&config.LogLevel, "HERA_LOG_LEVEL")
+	envString(&config.SyslogAddr, "HERA_SYSLOG_ADDR")
+	envString(&config.SyslogFacility, "HERA_SYSLOG_FACILITY")
+	envString(&config.MaintenanceAddr, "HERA_MAINTENANCE_ADDR")
+	envString(&config.MaintenancePages, "HERA_MAINTENANCE_PAGES")
+	envString(&config.OriginCAPath, "HERA_ORIGIN_CA_PATH")
+	envString(&config.AddressFamily, "HERA_ADDRESS_FAMILY")
+
+	err = envBool(&config.StopTunnelsOnExit, "HERA_STOP_TUNNELS_ON_EXIT")
+	if err != nil {
+		return nil, err
+	}
+
+	err = envBool(&config.LogRedact, "HERA_LOG_REDACT")
+	if err != nil {
+		return nil, err
+	}
+
+	err = envMap(&config.LogLevels, "HERA_LOG_LEVELS")
+	if err != nil {
+		return nil, err
+	}
+
+	err = envMap(&config.Cloudflared, "HERA_CLOUDFLARED")
+	if err != nil {
+		return nil, err
+	}
+
+	err = envBool(&config.LogCompress, "HERA_LOG_COMPRESS")
+	if err != nil {
+		return nil, err
+	}
+
+	err = envBool(&config.SyslogForwardTunnels, "HERA_SYSLOG_FORWARD_TUNNELS")
+	if err != nil {
+		return nil, err
+	}
+
+	if url, ok := os.LookupEnv("HERA_WEBHOOK_URL"); ok && url != "" {
+		webhook := &Webhook{URL: url}
+		envString(&webhook.Format, "HERA_WEBHOOK_FORMAT")
+		envString(&webhook.Secret, "HERA_WEBHOOK_SECRET")
+
+		if events := os.Getenv("HERA_WEBHOOK_EVENTS"); events != "" {
+			webhook.Events = strings.Split(events, ",")
+		}
+
+		config.Webhooks = append(config.Webhooks, webhook)
+	}
+
+	for name, value := range map[string]*int{
+		"HERA_LOG_MAX_SIZE":    &config.LogMaxSize,
+		"HERA_LOG_MAX_FILES":   &config.LogMaxFiles,
+		"HERA_WEBHOOK_RETRIES": &config.WebhookRetries,
+	} {
+		err = envInt(value, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = envInt(&config.CrashLoopThreshold, "HERA_CRASH_LOOP_THRESHOLD")
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range map[string]*Duration{
+		"HERA_CRASH_LOOP_WINDOW":      &config.CrashLoopWindow,
+		"HERA_CRASH_LOOP_BACKOFF":     &config.CrashLoopBackoff,
+		"HERA_CRASH_LOOP_MAX_BACKOFF": &config.CrashLoopMaxBackoff,
+		"HERA_LOG_MAX_AGE":            &config.LogMaxAge,
+		"HERA_WEBHOOK_BACKOFF":        &config.WebhookBackoff,
+		"HERA_STOP_DELAY":             &config.StopDelay,
+	} {
+		err = envDuration(value, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = checkCloudflaredSettings(config.Cloudflared)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkAddressFamily(config.AddressFamily)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// envString sets value to the given environment variable if it is defined
+func envString(value *string, name string) {
+	env, ok := os.LookupEnv(name)
+	if ok {
+		*value = env
+	}
+}
+
+// envInt sets value to the given environment variable if it is defined.
+// An error is returned if the variable is not an integer.
+func envInt(value *int, name string) error {
+	env, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	parsed, err := strconv.Atoi(env)
+	if err != nil {
+		return fmt.Errorf("Invalid value for %s: %s", name, env)
+	}
+
+	*value = parsed
+
+	return nil
+}
+
+// envBool sets value to the given environment variable if it is defined.
+// An error is returned if the variable is not a boolean.
+func envBool(value *bool, name string) error {
+	env, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	parsed, err := strconv.ParseBool(env)
+	if err != nil {
+		return fmt.Errorf("Invalid value for %s: %s", name, env)
+	}
+
+	*value = parsed
+
+	return nil
+}
+
+// envMap sets value to the given environment variable if it is defined, which holds
+// comma separated pairs such as "tunnel=debug,docker=warning".
+// An error is returned if a pair has no value.
+func envMap(value *map[string]string, name string) error {
+	env, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	parsed := make(map[string]string)
+
+	for _, pair := range strings.Split(env, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid value for %s: %s", name, env)
+		}
+
+		parsed[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	*value = parsed
+
+	return nil
+}
+
+// envDuration sets value to the given environment variable if it is defined.
+// An error is returned if the variable is not a duration.
+func envDuration(value *Duration, name string) error {
+	env, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(env)
+	if err != nil {
+		return fmt.Errorf("Invalid value for %s: %s", name, env)
+	}
+
+	value.Duration = parsed
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	connectionUnknown      = "unknown"
+	connectionConnecting   = "connecting"
+	connectionConnected    = "connected"
+	connectionDisconnected = "disconnected"
+	connectionFailed       = "failed"
+)
+
+// logEventKind identifies a cloudflared log line that changes the connection state of a tunnel
+type logEventKind int
+
+const (
+	logEventNone logEventKind = iota
+	logEventRegistered
+	logEventLost
+	logEventFatal
+)
+
+var (
+	registeredPattern = regexp.MustCompile(`(?i)(connected to [a-z]{3}|registered tunnel connection)`)
+	lostPattern       = regexp.MustCompile(`(?i)(unregistered tunnel connection|connection terminated|lost connection|serve tunnel error|register tunnel error)`)
+	fatalPattern      = regexp.MustCompile(`(?i)(level=(fatal|panic)|^\S*\s*(FTL|PNC)\s|unauthorized|cannot determine default origin certificate|error (parsing|reading) origin cert)`)
+	messagePattern    = regexp.MustCompile(`msg="((?:[^"\\]|\\.)*)"`)
+)
+
+// parseLogLine returns the kind of event a cloudflared log line describes along with its message
+func parseLogLine(line string) (logEventKind, string) {
+	switch {
+	case fatalPattern.MatchString(line):
+		return logEventFatal, logLineMessage(line)
+	case lostPattern.MatchString(line):
+		return logEventLost, logLineMessage(line)
+	case registeredPattern.MatchString(line):
+		return logEventRegistered, logLineMessage(line)
+	}
+
+	return logEventNone, ""
+}
+
+// logLineMessage returns the msg field of a logfmt line, or the whole line for other formats
+func logLineMessage(line string) string {
+	match := messagePattern.FindStringSubmatch(line)
+	if match != nil {
+		return strings.Replace(match[1], `\"`, `"`, -1)
+	}
+
+	return strings.TrimSpace(line)
+}
+
+// ConnectionStatus describes the connection between a tunnel and the Cloudflare edge as
+// reported by cloudflared
+type ConnectionStatus struct {
+	State       string    `json:"state"`
+	Connections int       `json:"connections"`
+	LastError   string    `json:"last_error,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at,omitempty"`
+}
+
+// apply updates the connection status with an event parsed from the log
+func (c *ConnectionStatus) apply(kind logEventKind, message string, at time.Time) {
+	switch kind {
+	case logEventRegistered:
+		c.Connections++
+		c.State = connectionConnected
+		c.LastError = ""
+
+	case logEventLost:
+		if c.Connections > 0 {
+			c.Connections--
+		}
+		if c.Connections == 0 && c.State != connectionFailed {
+			c.State = connectionDisconnected
+		}
+		c.LastError = message
+
+	case logEventFatal:
+		c.Connections = 0
+		c.State = connectionFailed
+		c.LastError = message
+
+	default:
+		return
+	}
+
+	c.UpdatedAt = at
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLogLine(t *testing.T) {
+	lines := map[string]logEventKind{
+		`time="2018-08-11T08:38:41Z" level=info msg="Connected to SEA"`:                                             logEventRegistered,
+		`2021-03-01T10:00:00Z INF Registered tunnel connection connIndex=0 location=ams01`:                          logEventRegistered,
+		`time="2018-08-11T09:00:53Z" level=error msg="Lost connection with the edge" connectionID=0`:                logEventLost,
+		`2021-03-01T10:00:00Z ERR Unregistered tunnel connection connIndex=1`:                                       logEventLost,
+		`time="2018-08-11T09:00:53Z" level=fatal msg="Cannot determine default origin certificate path"`:            logEventFatal,
+		`time="2018-08-11T09:00:53Z" level=error msg="Register tunnel error from server side" error="Unauthorized"`: logEventFatal,
+		`2021-03-01T10:00:00Z FTL Tunnel credentials file not found`:                                                logEventFatal,
+		`time="2018-08-11T08:38:40Z" level=info msg="Starting metrics server" addr="127.0.0.1:40521"`:               logEventNone,
+	}
+
+	for line, expected := range lines {
+		actual, _ := parseLogLine(line)
+		if actual != expected {
+			t.Errorf("Unexpected event for %q, want %d got %d", line, expected, actual)
+		}
+	}
+}
+
+func TestLogLineMessage(t *testing.T) {
+	actual := logLineMessage(`time="2018-08-11T09:00:53Z" level=fatal msg="Unable to \"connect\"" error=x`)
+	expected := `Unable to "connect"`
+
+	if actual != expected {
+		t.Errorf("Unexpected message, want %s got %s", expected, actual)
+	}
+}
+
+func TestConnectionStatusApply(t *testing.T) {
+	status := ConnectionStatus{State: connectionConnecting}
+	now := time.Now()
+
+	status.apply(logEventRegistered, "", now)
+	status.apply(logEventRegistered, "", now)
+	if status.State != connectionConnected || status.Connections != 2 {
+		t.Errorf("Expected two connections, got %+v", status)
+	}
+
+	status.apply(logEventLost, "lost", now)
+	if status.State != connectionConnected || status.Connections != 1 {
+		t.Errorf("Expected one connection, got %+v", status)
+	}
+
+	status.apply(logEventLost, "lost", now)
+	if status.State != connectionDisconnected || status.LastError != "lost" {
+		t.Errorf("Expected disconnected, got %+v", status)
+	}
+
+	status.apply(logEventFatal, "fatal", now)
+	if status.State != connectionFailed {
+		t.Errorf("Expected failed, got %+v", status)
+	}
+}
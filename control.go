@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	defaultLogLines = 50
+	maxLogLines     = 10000
+
+	controlSocketMode = 0600
+)
+
+// errPeerCredentialsUnsupported is returned when the user of a control socket client cannot be
+// checked on this platform
+var errPeerCredentialsUnsupported = errors.New("Peer credentials are not supported")
+
+// ControlServer handles requests on the control socket, managing tunnels at runtime
+type ControlServer struct {
+	Listener *Listener
+}
+
+// NewControlServer returns an HTTP server for the control socket and starts serving on the
+// Unix socket at path. A stale socket left by a previous run is replaced. Only root and the
+// user Hera runs as may connect to the socket.
+func NewControlServer(path string, listener *Listener) (*http.Server, error) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	socket, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(path, controlSocketMode)
+	if err != nil {
+		socket.Close()
+		return nil, err
+	}
+
+	control := &ControlServer{Listener: listener}
+
+	mux := http.NewServeMux()
+	control.registerRoutes(mux)
+
+	server := &http.Server{
+		Addr:    path,
+		Handler: mux,
+	}
+
+	go func() {
+		apiLog.Infof("Serving control socket on %s", path)
+
+		err := server.Serve(&controlSocketListener{socket})
+		if err != nil && err != http.ErrServerClosed {
+			apiLog.Errorf("Unable to serve control socket on %s: %s", path, err)
+		}
+	}()
+
+	return server, nil
+}
+
+// controlSocketListener accepts connections from root and the user Hera runs as, closing
+// connections from any other user
+type controlSocketListener struct {
+	net.Listener
+}
+
+func (l *controlSocketListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		uid, err := peerUID(conn)
+		if err == errPeerCredentialsUnsupported || (err == nil && controlUserAllowed(uid)) {
+			return conn, nil
+		}
+
+		if err != nil {
+			apiLog.Warningf("Rejected control socket connection: %s", err)
+		} else {
+			apiLog.Warningf("Rejected control socket connection from uid %d", uid)
+		}
+
+		conn.Close()
+	}
+}
+
+// controlUserAllowed returns true if the user may use the control socket
+func controlUserAllowed(uid int) bool {
+	return uid == 0 || uid == os.Getuid()
+}
+
+// registerRoutes adds the control endpoints to the given mux
+func (c *ControlServer) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/tunnels", handleListTunnels)
+	mux.HandleFunc("/tunnels/", c.handleTunnel)
+	mux.HandleFunc("/reconcile", c.handleReconcile)
+	mux.HandleFunc("/certificates/reload", c.handleReloadCertificates)
+}
+
+// TunnelInspection describes a tunnel in detail, including the files of its service
+type TunnelInspection struct {
+	Status      *TunnelStatus `json:"status"`
+	MetricsAddr string        `json:"metrics_addr,omitempty"`
+	ConfigFile  string        `json:"config_file"`
+	Config      string        `json:"config"`
+	RunFile     string        `json:"run_file"`
+	Run         string        `json:"run"`
+	LogFile     string        `json:"log_file"`
+}
+
+// Inspect returns the detailed description of a tunnel
+func (t *Tunnel) Inspect(ctx context.Context) *TunnelInspection {
+	inspection := &TunnelInspection{
+		Status:      t.Status(ctx),
+		MetricsAddr: t.config().MetricsAddr,
+		ConfigFile:  t.Service.ConfigFilePath(),
+		RunFile:     t.Service.RunFilePath(),
+		LogFile:     t.Service.LogFilePath(),
+	}
+
+	config, err := afero.ReadFile(fs, inspection.ConfigFile)
+	if err == nil {
+		inspection.Config = string(config)
+	}
+
+	run, err := afero.ReadFile(fs, inspection.RunFile)
+	if err == nil {
+		inspection.Run = string(run)
+	}
+
+	return inspection
+}
+
+// handleTunnel dispatches requests for a single tunnel, which are of the form
+// /tunnels/<hostname>[/<action>]
+func (c *ControlServer) handleTunnel(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/tunnels/")
+	parts := strings.SplitN(path, "/", 2)
+
+	hostname := parts[0]
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	tunnel, err := GetTunnelForHost(hostname)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, tunnel.Status(r.Context()))
+
+	case action == "inspect" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, tunnel.Inspect(r.Context()))
+
+	case action == "logs" && r.Method == http.MethodGet:
+		handleTunnelLogs(w, r, tunnel)
+
+	case (action == "restart" || action == "start") && r.Method == http.MethodPost:
+		withFields(apiLog, Fields{fieldHostname: hostname}).Infof("Starting tunnel %s on request", hostname)
+		c.handleOperation(w, r, tunnel, tunnel.Start)
+
+	case action == "stop" && r.Method == http.MethodPost:
+		withFields(apiLog, Fields{fieldHostname: hostname}).Infof("Stopping tunnel %s on request", hostname)
+		stopProbing(hostname, "")
+		cancelStop(hostname)
+		c.handleOperation(w, r, tunnel, tunnel.Stop)
+
+	default:
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("Unknown request %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// handleOperation runs an operation that starts or stops a tunnel and responds with the
+// resulting status of the tunnel
+func (c *ControlServer) handleOperation(w http.ResponseWriter, r *http.Request, tunnel *Tunnel, operation func(context.Context) error) {
+	tunnelOperations.Lock()
+	err := operation(r.Context())
+	tunnelOperations.Unlock()
+
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tunnel.Status(r.Context()))
+}
+
+// handleReconcile revives the tunnels of running containers and stops the tunnels of
+// containers that are no longer running
+func (c *ControlServer) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	apiLog.Info("Reconciling tunnels on request")
+
+	report, err := c.Listener.Reconcile(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	apiLog.Infof("Reconciled tunnels: %s", report)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleReloadCertificates restarts the tunnels whose certificates have changed
+func (c *ControlServer) handleReloadCertificates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	apiLog.Info("Reloading certificates on request")
+
+	err := VerifyCertificates(c.Listener.Fs)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, c.Listener.ReloadCertificates(r.Context()))
+}
+
+// handleTunnelLogs responds with the last lines of the tunnel log file
+func handleTunnelLogs(w http.ResponseWriter, r *http.Request, tunnel *Tunnel) {
+	lines := defaultLogLines
+
+	if value := r.URL.Query().Get("lines"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxLogLines {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid number of lines %q", value))
+			return
+		}
+
+		lines = parsed
+	}
+
+	tail, err := tailFile(fs, tunnel.Service.LogFilePath(), lines)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	for _, line := range tail {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// tailFile returns up to the last n lines of the file at path
+func tailFile(fs afero.Fs, path string, n int) ([]string, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// ControlClient sends requests to the control socket of a running Hera daemon
+type ControlClient struct {
+	Socket string
+	client *http.Client
+}
+
+// NewControlClient returns a new ControlClient for the Unix socket at path
+func NewControlClient(path string) *ControlClient {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}
+
+	client := &ControlClient{
+		Socket: path,
+		client: &http.Client{Transport: transport},
+	}
+
+	return client
+}
+
+// Get sends a GET request to the daemon and returns the response body
+func (c *ControlClient) Get(path string) ([]byte, error) {
+	return c.do(http.MethodGet, path)
+}
+
+// Post sends a POST request to the daemon and returns the response body
+func (c *ControlClient) Post(path string) ([]byte, error) {
+	return c.do(http.MethodPost, path)
+}
+
+// GetJSON sends a GET request to the daemon and decodes the JSON response into value
+func (c *ControlClient) GetJSON(path string, value interface{}) error {
+	body, err := c.Get(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, value)
+}
+
+// do sends a request to the daemon. An error is returned if the daemon cannot be reached or
+// responds with an error.
+func (c *ControlClient) do(method, path string) ([]byte, error) {
+	req, err := http.NewRequest(method, "http://hera"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to reach Hera at %s, is the daemon running? (%s)", c.Socket, err)
+	}
+	defer resp.Body.Close()
+
+	var body bytes.Buffer
+	_, err = io.Copy(&body, resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+
+		if json.Unmarshal(body.Bytes(), &apiErr) == nil && apiErr.Error != "" {
+			return nil, errors.New(apiErr.Error)
+		}
+
+		return nil, fmt.Errorf("Unexpected response %s", resp.Status)
+	}
+
+	return body.Bytes(), nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestTailFile(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+	afero.WriteFile(memFs, "/log", []byte("one\ntwo\nthree\n"), 0644)
+
+	lines, err := tailFile(memFs, "/log", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(lines, []string{"two", "three"}) {
+		t.Errorf("Unexpected lines, got %v", lines)
+	}
+}
+
+func TestControlSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hera")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "hera.sock")
+
+	server, err := NewControlServer(socket, &Listener{Fs: afero.NewMemMapFs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	fs = afero.NewMemMapFs()
+	tunnel := newTunnel()
+	tunnel.Service.Commander = &MockCommander{
+		mockRun: func() ([]byte, error) {
+			return []byte("true true 12 -1 NA 30"), nil
+		},
+	}
+	registerTunnel(tunnel)
+	afero.WriteFile(fs, tunnel.Service.LogFilePath(), []byte("first\nsecond\n"), 0644)
+
+	client := NewControlClient(socket)
+
+	var status TunnelStatus
+	err = client.GetJSON("/tunnels/site.tld", &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.State != stateRunning || status.Service.PID != 12 {
+		t.Errorf("Unexpected status, got %+v", status)
+	}
+
+	body, err := client.Get("/tunnels/site.tld/logs?lines=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "second\n" {
+		t.Errorf("Unexpected logs, got %q", body)
+	}
+
+	_, err = client.Get("/tunnels/missing.tld")
+	if err == nil || !strings.Contains(err.Error(), "No tunnel exists") {
+		t.Errorf("Expected missing tunnel error, got %v", err)
+	}
+
+	_, err = client.Get("/tunnels/site.tld/restart")
+	if err == nil {
+		t.Error("Expected error for GET restart")
+	}
+
+	tunnel.writeConfigFile()
+
+	var inspection TunnelInspection
+	err = client.GetJSON("/tunnels/site.tld/inspect", &inspection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inspection.Config != tunnel.renderConfig() || inspection.Status.Hostname != "site.tld" {
+		t.Errorf("Unexpected inspection, got %+v", inspection)
+	}
+
+	_, err = client.Post("/tunnels/site.tld/stop")
+	if err != nil {
+		t.Errorf("Unexpected error stopping tunnel, got %s", err)
+	}
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != controlSocketMode {
+		t.Errorf("Unexpected socket mode, got %s", info.Mode())
+	}
+}
+
+func TestControlUserAllowed(t *testing.T) {
+	if !controlUserAllowed(0) || !controlUserAllowed(os.Getuid()) {
+		t.Error("Expected root and the current user to be allowed")
+	}
+
+	if os.Getuid() != 12345 && controlUserAllowed(12345) {
+		t.Error("Expected other users to be rejected")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	crashLoopPollInterval = 5 * time.Second
+)
+
+// CrashLoopPolicy decides when a restarting tunnel service is considered to be crash looping
+// and how long to wait before trying it again
+type CrashLoopPolicy struct {
+	Threshold  int
+	Window     time.Duration
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// crashLoopPolicy is the policy applied to every tunnel. Crash loop detection is disabled
+// when it is nil.
+var crashLoopPolicy = &CrashLoopPolicy{
+	Threshold:  5,
+	Window:     time.Minute,
+	Backoff:    30 * time.Second,
+	MaxBackoff: 10 * time.Minute,
+}
+
+// CrashLoopStatus describes the restarts of a tunnel service
+type CrashLoopStatus struct {
+	Restarts int       `json:"restarts"`
+	Failed   bool      `json:"failed"`
+	Reason   string    `json:"reason,omitempty"`
+	RetryAt  time.Time `json:"retry_at,omitempty"`
+}
+
+// crashLoopDetector counts the restarts of a service within the policy window
+type crashLoopDetector struct {
+	policy   *CrashLoopPolicy
+	lastPID  int
+	restarts []time.Time
+	total    int
+	backoff  time.Duration
+}
+
+func newCrashLoopDetector(policy *CrashLoopPolicy) *crashLoopDetector {
+	detector := &crashLoopDetector{
+		policy:  policy,
+		lastPID: -1,
+		backoff: policy.Backoff,
+	}
+
+	return detector
+}
+
+// observe records a service status. It returns whether the service has restarted since the
+// previous status and whether it has restarted as many times as the policy threshold within
+// the policy window.
+func (d *crashLoopDetector) observe(status *ServiceStatus, now time.Time) (restarted bool, flapping bool) {
+	if status.PID > 0 {
+		if d.lastPID > 0 && status.PID != d.lastPID {
+			restarted = true
+		}
+		d.lastPID = status.PID
+	} else if status.WantedUp && d.lastPID > 0 {
+		restarted = true
+		d.lastPID = -1
+	}
+
+	if restarted {
+		d.restarts = append(d.restarts, now)
+		d.total++
+	}
+
+	cutoff := now.Add(-d.policy.Window)
+	for len(d.restarts) > 0 && d.restarts[0].Before(cutoff) {
+		d.restarts = d.restarts[1:]
+	}
+
+	if status.Up && status.Duration >= d.policy.Window {
+		d.backoff = d.policy.Backoff
+	}
+
+	return restarted, len(d.restarts) >= d.policy.Threshold
+}
+
+// nextBackoff returns the delay before the service is tried again, doubling it for the next
+// crash loop up to the policy maximum
+func (d *crashLoopDetector) nextBackoff() time.Duration {
+	backoff := d.backoff
+
+	d.backoff *= 2
+	if d.backoff > d.policy.MaxBackoff {
+		d.backoff = d.policy.MaxBackoff
+	}
+
+	return backoff
+}
+
+// reset forgets the restarts seen so far, keeping the current backoff
+func (d *crashLoopDetector) reset() {
+	d.restarts = nil
+	d.lastPID = -1
+}
+
+// crashLoopReason describes why a service is considered to be crash looping
+func crashLoopReason(restarts int, window time.Duration, status *ServiceStatus) string {
+	reason := fmt.Sprintf("restarted %d times within %s", restarts, window)
+
+	if status.Signal != "" {
+		return fmt.Sprintf("%s, last killed by %s", reason, status.Signal)
+	}
+
+	if status.ExitCode >= 0 {
+		return fmt.Sprintf("%s, last exit code %d", reason, status.ExitCode)
+	}
+
+	return reason
+}
+
+// CrashLoopMonitor polls the status of a tunnel service, stopping the service when it crash
+// loops and starting it again after a backoff that increases with each crash loop
+type CrashLoopMonitor struct {
+	Tunnel *Tunnel
+	Policy *CrashLoopPolicy
+
+	once   sync.Once
+	stop   chan struct{}
+	done   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewCrashLoopMonitor returns a new CrashLoopMonitor for a tunnel
+func NewCrashLoopMonitor(tunnel *Tunnel, policy *CrashLoopPolicy) *CrashLoopMonitor {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	monitor := &CrashLoopMonitor{
+		Tunnel: tunnel,
+		Policy: policy,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	return monitor
+}
+
+// Start monitors the tunnel service in the background until the monitor is stopped
+func (m *CrashLoopMonitor) Start() {
+	go func() {
+		defer close(m.done)
+
+		detector := newCrashLoopDetector(m.Policy)
+		hostname := m.Tunnel.Config.Hostname
+		logger := m.Tunnel.logger()
+
+		for {
+			if !m.wait(crashLoopPollInterval) {
+				return
+			}
+
+			status, err := m.Tunnel.Service.Status(m.ctx)
+			if err != nil {
+				continue
+			}
+
+			restarted, flapping := detector.observe(status, time.Now())
+			if restarted {
+				metrics.TunnelRestarts.Inc(hostname)
+				m.Tunnel.setRestarts(detector.total)
+			}
+
+			if !flapping {
+				continue
+			}
+
+			backoff := detector.nextBackoff()
+			reason := crashLoopReason(len(detector.restarts), m.Policy.Window, status)
+
+			logger.With(fieldTunnelState, stateFailed).Errorf("Tunnel %s is crash looping (%s), retrying in %s", hostname, reason, backoff)
+			metrics.TunnelCrashLoops.Inc(hostname)
+			m.Tunnel.setCrashLoop(reason, time.Now().Add(backoff))
+			config := m.Tunnel.config()
+			notify(eventTunnelFailed, &config, fmt.Errorf("Crash looping, %s", reason))
+
+			err = m.Tunnel.Service.Stop(m.ctx)
+			if err != nil {
+				logger.Errorf("Unable to stop crash looping tunnel %s: %s", hostname, err)
+			}
+
+			if !m.wait(backoff) {
+				return
+			}
+
+			logger.Infof("Retrying tunnel %s", hostname)
+			detector.reset()
+			m.Tunnel.clearCrashLoop()
+
+			err = m.Tunnel.Service.Start(m.ctx)
+			if err != nil {
+				logger.Errorf("Unable to start tunnel %s: %s", hostname, err)
+			}
+		}
+	}()
+}
+
+// Stop stops monitoring and waits for the monitor to finish. A service command in progress is
+// cancelled.
+func (m *CrashLoopMonitor) Stop() {
+	m.once.Do(func() {
+		close(m.stop)
+		m.cancel()
+	})
+
+	<-m.done
+}
+
+// wait returns false if the monitor is stopped before the duration has passed
+func (m *CrashLoopMonitor) wait(duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-m.stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCrashLoopDetector(t *testing.T) {
+	policy := &CrashLoopPolicy{
+		Threshold:  3,
+		Window:     time.Minute,
+		Backoff:    time.Second,
+		MaxBackoff: 3 * time.Second,
+	}
+	detector := newCrashLoopDetector(policy)
+	now := time.Now()
+
+	statuses := []*ServiceStatus{
+		{Up: true, WantedUp: true, PID: 10},
+		{Up: true, WantedUp: true, PID: 11},
+		{Up: false, WantedUp: true, PID: -1, ExitCode: 1},
+		{Up: true, WantedUp: true, PID: 12},
+		{Up: true, WantedUp: true, PID: 13},
+	}
+
+	var flapping bool
+	for i, status := range statuses {
+		_, flapping = detector.observe(status, now.Add(time.Duration(i)*time.Second))
+	}
+
+	if !flapping {
+		t.Error("Expected service to be flapping")
+	}
+
+	if detector.total != 3 {
+		t.Errorf("Unexpected restart count, got %d", detector.total)
+	}
+
+	backoffs := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	for _, expected := range backoffs {
+		if backoff := detector.nextBackoff(); backoff != expected {
+			t.Errorf("Unexpected backoff, want %s got %s", expected, backoff)
+		}
+	}
+
+	detector.reset()
+	_, flapping = detector.observe(&ServiceStatus{Up: true, PID: 14, Duration: 2 * time.Minute}, now.Add(3*time.Minute))
+	if flapping {
+		t.Error("Expected service to be stable")
+	}
+
+	if backoff := detector.nextBackoff(); backoff != time.Second {
+		t.Errorf("Expected backoff to reset, got %s", backoff)
+	}
+}
+
+func TestCrashLoopDetectorWindow(t *testing.T) {
+	policy := &CrashLoopPolicy{Threshold: 2, Window: time.Minute, Backoff: time.Second, MaxBackoff: time.Second}
+	detector := newCrashLoopDetector(policy)
+	now := time.Now()
+
+	detector.observe(&ServiceStatus{Up: true, PID: 1}, now)
+	detector.observe(&ServiceStatus{Up: true, PID: 2}, now)
+	_, flapping := detector.observe(&ServiceStatus{Up: true, PID: 3}, now.Add(2*time.Minute))
+
+	if flapping {
+		t.Error("Expected restarts outside the window to be ignored")
+	}
+}
+
+func TestCrashLoopReason(t *testing.T) {
+	reason := crashLoopReason(5, time.Minute, &ServiceStatus{ExitCode: 1})
+	if reason != "restarted 5 times within 1m0s, last exit code 1" {
+		t.Errorf("Unexpected reason, got %s", reason)
+	}
+
+	reason = crashLoopReason(5, time.Minute, &ServiceStatus{ExitCode: -1, Signal: "SIGSEGV"})
+	if reason != "restarted 5 times within 1m0s, last killed by SIGSEGV" {
+		t.Errorf("Unexpected reason, got %s", reason)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+)
+
+// diffLines returns a line diff between two texts. Each line is prefixed with " " when it is
+// in both texts, "-" when it is only in the old text and "+" when it is only in the new text.
+func diffLines(oldText, newText string) []string {
+	a := splitLines(oldText)
+	b := splitLines(newText)
+
+	// lcs[i][j] holds the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+a[i])
+			i++
+		default:
+			diff = append(diff, "+"+b[j])
+			j++
+		}
+	}
+
+	for ; i < len(a); i++ {
+		diff = append(diff, "-"+a[i])
+	}
+
+	for ; j < len(b); j++ {
+		diff = append(diff, "+"+b[j])
+	}
+
+	return diff
+}
+
+// hasChanges returns true if a diff contains added or removed lines
+func hasChanges(diff []string) bool {
+	for _, line := range diff {
+		if !strings.HasPrefix(line, " ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	oldText := "hostname: site.tld\nurl: 172.23.0.4:80\nno-autoupdate: true"
+	newText := "hostname: site.tld\nurl: 172.23.0.5:80\nno-autoupdate: true\nmetrics: 127.0.0.1:41000"
+
+	expected := []string{
+		" hostname: site.tld",
+		"-url: 172.23.0.4:80",
+		"+url: 172.23.0.5:80",
+		" no-autoupdate: true",
+		"+metrics: 127.0.0.1:41000",
+	}
+
+	actual := diffLines(oldText, newText)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected diff, want %v got %v", expected, actual)
+	}
+
+	if !hasChanges(actual) {
+		t.Error("Expected changes")
+	}
+}
+
+func TestDiffLinesUnchanged(t *testing.T) {
+	diff := diffLines("a\nb", "a\nb")
+	if hasChanges(diff) {
+		t.Errorf("Expected no changes, got %v", diff)
+	}
+
+	diff = diffLines("", "a")
+	if !reflect.DeepEqual(diff, []string{"+a"}) {
+		t.Errorf("Unexpected diff for new file, got %v", diff)
+	}
+}
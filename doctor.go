@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/spf13/afero"
+)
+
+// doctorTools are the commands Hera needs on its PATH to run tunnels
+var doctorTools = []string{"cloudflared", "s6-svc", "s6-svscanctl", "s6-svstat", "s6-svwait"}
+
+// toolVersionArgs holds the arguments that print the version of a tool. Tools without an
+// entry have no way of reporting their version.
+var toolVersionArgs = map[string][]string{
+	"cloudflared": {"--version"},
+}
+
+// lookPath finds a command on the PATH, replaced in tests
+var lookPath = exec.LookPath
+
+// DoctorResult is the outcome of a single check made by Doctor
+type DoctorResult struct {
+	Subject string
+	Detail  string
+	Err     error
+}
+
+// Doctor checks the environment Hera runs in: the Docker connection, the tools used to run
+// tunnels, the certificates, the writable directories and the network and certificate of every
+// labelled container
+func Doctor(client *Client, fs afero.Fs, commander Commander) []*DoctorResult {
+	var results []*DoctorResult
+
+	results = append(results, checkDocker(client))
+	results = append(results, checkTools(commander)...)
+	results = append(results, checkCertificates(fs)...)
+
+	for _, dir := range []string{LogPath, ServicesPath} {
+		results = append(results, checkWritable(fs, dir))
+	}
+
+	return append(results, checkContainers(client, fs)...)
+}
+
+// checkDocker returns an error if the Docker daemon cannot be reached or its API is older than
+// the version Hera uses
+func checkDocker(client *Client) *DoctorResult {
+	result := &DoctorResult{Subject: "docker"}
+
+	version, err := client.DockerClient.ServerVersion(context.Background())
+	if err != nil {
+		result.Err = fmt.Errorf("Unable to connect to Docker at %s: %s", Socket, err)
+		return result
+	}
+
+	result.Detail = fmt.Sprintf("Docker %s, API %s", version.Version, version.APIVersion)
+
+	required := strings.TrimPrefix(APIVersion, "v")
+	if versions.LessThan(version.APIVersion, required) {
+		result.Err = fmt.Errorf("Docker API %s is older than the required %s", version.APIVersion, required)
+	}
+
+	return result
+}
+
+// checkTools returns a result for every tool Hera needs, with its path and version if known
+func checkTools(commander Commander) []*DoctorResult {
+	var results []*DoctorResult
+
+	for _, name := range doctorTools {
+		result := &DoctorResult{Subject: name}
+		results = append(results, result)
+
+		path, err := lookPath(name)
+		if err != nil {
+			result.Err = errors.New("Not found on PATH")
+			continue
+		}
+
+		result.Detail = path
+
+		args, ok := toolVersionArgs[name]
+		if !ok {
+			continue
+		}
+
+		out, err := commander.Run(context.Background(), name, args...)
+		if err != nil {
+			result.Err = fmt.Errorf("Unable to get version: %s", err)
+			continue
+		}
+
+		version := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0]
+		result.Detail = fmt.Sprintf("%s (%s)", path, version)
+	}
+
+	return results
+}
+
+// checkCertificates returns a result for every certificate, failing certificates that cannot
+// be parsed
+func checkCertificates(fs afero.Fs) []*DoctorResult {
+	certs, err := FindAllCertificates(fs)
+	if err == nil && len(certs) == 0 {
+		err = errors.New("No certificates found")
+	}
+
+	if err != nil {
+		return []*DoctorResult{{Subject: CertificatePath, Err: err}}
+	}
+
+	var results []*DoctorResult
+
+	for _, cert := range certs {
+		results = append(results, &DoctorResult{
+			Subject: cert.FullPath(),
+			Err:     parseCertificate(cert),
+		})
+	}
+
+	return results
+}
+
+// parseCertificate returns an error if the certificate file does not contain PEM blocks or
+// contains an invalid X.509 certificate
+func parseCertificate(cert *Certificate) error {
+	rest, err := afero.ReadFile(cert.Fs, cert.FullPath())
+	if err != nil {
+		return err
+	}
+
+	blocks := 0
+
+	for {
+		var block *pem.Block
+
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		blocks++
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		_, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("Invalid certificate: %s", err)
+		}
+	}
+
+	if blocks == 0 {
+		return errors.New("No PEM data found")
+	}
+
+	return nil
+}
+
+// checkWritable returns an error if a file cannot be created in the directory
+func checkWritable(fs afero.Fs, dir string) *DoctorResult {
+	result := &DoctorResult{Subject: dir, Detail: "writable"}
+
+	file, err := afero.TempFile(fs, dir, ".hera-doctor")
+	if err != nil {
+		result.Err = fmt.Errorf("Not writable: %s", err)
+		return result
+	}
+
+	file.Close()
+	fs.Remove(file.Name())
+
+	return result
+}
+
+// checkContainers returns a result for every labelled container, failing containers that do
+// not share a network with Hera or have no certificate for their hostname
+func checkContainers(client *Client, fs afero.Fs) []*DoctorResult {
+	containers, err := client.ListContainers()
+	if err != nil {
+		return []*DoctorResult{{Subject: "containers", Err: err}}
+	}
+
+	var results []*DoctorResult
+
+	hera, heraErr := heraContainer(client)
+	if heraErr != nil {
+		results = append(results, &DoctorResult{Subject: "hera container", Err: heraErr})
+	}
+
+	for _, c := range containers {
+		container, err := client.Inspect(c.ID)
+		if err != nil {
+			results = append(results, &DoctorResult{Subject: shortID(c.ID), Err: err})
+			continue
+		}
+
+		hostname := getLabel(heraHostname, container)
+		if hostname == "" || (heraErr == nil && container.ID == hera.ID) {
+			continue
+		}
+
+		result := &DoctorResult{Subject: fmt.Sprintf("%s (%s)", hostname, shortID(container.ID))}
+		results = append(results, result)
+
+		cert, err := findCertificate(hostname, fs)
+		if err != nil {
+			result.Err = err
+			continue
+		}
+
+		if heraErr != nil {
+			result.Detail = fmt.Sprintf("certificate %s", cert.Name)
+			continue
+		}
+
+		networks := sharedNetworks(hera, container)
+		if len(networks) == 0 {
+			result.Err = errors.New("Does not share a network with Hera")
+			continue
+		}
+
+		result.Detail = fmt.Sprintf("certificate %s, network %s", cert.Name, strings.Join(networks, ", "))
+	}
+
+	return results
+}
+
+// heraContainer returns the container Hera runs in, which Docker names after its hostname
+func heraContainer(client *Client) (types.ContainerJSON, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+
+	container, err := client.Inspect(hostname)
+	if err != nil {
+		return types.ContainerJSON{}, fmt.Errorf("Unable to find the Hera container: %s", err)
+	}
+
+	return container, nil
+}
+
+// sharedNetworks returns the sorted names of the networks both containers are attached to
+func sharedNetworks(a, b types.ContainerJSON) []string {
+	var shared []string
+
+	if a.NetworkSettings == nil || b.NetworkSettings == nil {
+		return shared
+	}
+
+	for name := range a.NetworkSettings.Networks {
+		if _, ok := b.NetworkSettings.Networks[name]; ok {
+			shared = append(shared, name)
+		}
+	}
+
+	sort.Strings(shared)
+
+	return shared
+}
+
+// RunDoctor prints the result of every check and returns the exit code, which is non-zero if
+// any check fails
+func RunDoctor(w io.Writer) int {
+	client, err := NewClient()
+	if err != nil {
+		fmt.Fprintf(w, "FAIL  docker: %s\n", err)
+		return 1
+	}
+
+	passed, failed := 0, 0
+
+	for _, result := range Doctor(client, afero.NewOsFs(), Command{}) {
+		if result.Err != nil {
+			fmt.Fprintf(w, "FAIL  %s: %s\n", result.Subject, result.Err)
+			failed++
+			continue
+		}
+
+		if result.Detail != "" {
+			fmt.Fprintf(w, "OK    %s: %s\n", result.Subject, result.Detail)
+		} else {
+			fmt.Fprintf(w, "OK    %s\n", result.Subject)
+		}
+		passed++
+	}
+
+	fmt.Fprintf(w, "\n%d passed, %d failed\n", passed, failed)
+
+	if failed > 0 {
+		return 1
+	}
+
+	return 0
+}
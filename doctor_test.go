@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/pem"
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/spf13/afero"
+)
+
+func TestParseCertificate(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	token := pem.EncodeToMemory(&pem.Block{Type: "ARGO TUNNEL TOKEN", Bytes: []byte("token")})
+	invalid := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("invalid")})
+
+	afero.WriteFile(fs, "/certs/valid.tld.pem", token, 0644)
+	afero.WriteFile(fs, "/certs/empty.tld.pem", []byte("not pem"), 0644)
+	afero.WriteFile(fs, "/certs/invalid.tld.pem", append(token, invalid...), 0644)
+
+	err := parseCertificate(NewCertificate("valid.tld.pem", fs))
+	if err != nil {
+		t.Errorf("Unexpected error for valid certificate, got %s", err)
+	}
+
+	err = parseCertificate(NewCertificate("empty.tld.pem", fs))
+	if err == nil {
+		t.Error("Expected error for certificate without PEM data")
+	}
+
+	err = parseCertificate(NewCertificate("invalid.tld.pem", fs))
+	if err == nil {
+		t.Error("Expected error for invalid certificate")
+	}
+}
+
+func TestCheckTools(t *testing.T) {
+	defer func() { lookPath = exec.LookPath }()
+
+	lookPath = func(name string) (string, error) {
+		if name == "s6-svwait" {
+			return "", errors.New("not found")
+		}
+
+		return "/bin/" + name, nil
+	}
+
+	commander := &MockCommander{
+		mockRun: func() ([]byte, error) {
+			return []byte("cloudflared version 2019.3.0\n"), nil
+		},
+	}
+
+	results := checkTools(commander)
+	if len(results) != len(doctorTools) {
+		t.Fatalf("Unexpected number of results, got %d", len(results))
+	}
+
+	if results[0].Detail != "/bin/cloudflared (cloudflared version 2019.3.0)" {
+		t.Errorf("Unexpected cloudflared detail, got %s", results[0].Detail)
+	}
+
+	for _, result := range results {
+		if (result.Err != nil) != (result.Subject == "s6-svwait") {
+			t.Errorf("Unexpected result for %s, got %v", result.Subject, result.Err)
+		}
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	result := checkWritable(fs, LogPath)
+	if result.Err != nil {
+		t.Errorf("Unexpected error, got %s", result.Err)
+	}
+
+	result = checkWritable(afero.NewReadOnlyFs(fs), LogPath)
+	if result.Err == nil {
+		t.Error("Expected error for read only directory")
+	}
+}
+
+func TestSharedNetworks(t *testing.T) {
+	withNetworks := func(names ...string) types.ContainerJSON {
+		networks := make(map[string]*network.EndpointSettings)
+		for _, name := range names {
+			networks[name] = &network.EndpointSettings{}
+		}
+
+		return types.ContainerJSON{NetworkSettings: &types.NetworkSettings{Networks: networks}}
+	}
+
+	shared := sharedNetworks(withNetworks("hera", "web", "bridge"), withNetworks("web", "hera"))
+	if !reflect.DeepEqual(shared, []string{"hera", "web"}) {
+		t.Errorf("Unexpected shared networks, got %v", shared)
+	}
+
+	shared = sharedNetworks(withNetworks("hera"), withNetworks("bridge"))
+	if len(shared) != 0 {
+		t.Errorf("Expected no shared networks, got %v", shared)
+	}
+}
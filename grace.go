@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	heraStopDelay = "hera.stop-delay"
+)
+
+var (
+	// tunnelStopDelay is how long the tunnel of a container that died is kept running in case the
+	// container starts again. Tunnels are taken down immediately when it is zero.
+	tunnelStopDelay time.Duration
+
+	pendingStops   = make(map[string]*pendingStop)
+	pendingStopsMu sync.Mutex
+)
+
+// pendingStop is a tunnel waiting to be taken down after its container died. Cancelling the
+// stop cancels its service commands.
+type pendingStop struct {
+	timer  *time.Timer
+	cancel context.CancelFunc
+}
+
+// containerStopDelay returns how long the tunnel of a container is kept running after the
+// container dies, from the hera.stop-delay label or the configured default.
+// An error is returned if the label is not a duration.
+func containerStopDelay(container types.ContainerJSON) (time.Duration, error) {
+	value := getLabel(heraStopDelay, container)
+	if value == "" {
+		return tunnelStopDelay, nil
+	}
+
+	delay, err := time.ParseDuration(value)
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("Invalid %s label %q", heraStopDelay, value)
+	}
+
+	return delay, nil
+}
+
+// scheduleStop takes down a tunnel once the delay has passed, unless the stop is cancelled
+// because the container of the tunnel has started again
+func scheduleStop(tunnel *Tunnel, delay time.Duration) {
+	hostname := tunnel.Config.Hostname
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := &pendingStop{cancel: cancel}
+
+	pendingStopsMu.Lock()
+	defer pendingStopsMu.Unlock()
+
+	if previous, ok := pendingStops[hostname]; ok {
+		previous.timer.Stop()
+		previous.cancel()
+	}
+
+	pendingStops[hostname] = stop
+	stop.timer = time.AfterFunc(delay, func() {
+		tunnelOperations.Lock()
+		defer tunnelOperations.Unlock()
+
+		defer cancel()
+
+		pendingStopsMu.Lock()
+		current := pendingStops[hostname]
+		if current == stop {
+			delete(pendingStops, hostname)
+		}
+		pendingStopsMu.Unlock()
+
+		registered, err := GetTunnelForHost(hostname)
+		if current != stop || err != nil || registered != tunnel {
+			return
+		}
+
+		tunnel.logger().Infof("Container for %s did not start again within %s", hostname, delay)
+
+		err = takeDown(ctx, tunnel)
+		if err != nil {
+			tunnel.logger().Errorf("%s", err)
+		}
+	})
+}
+
+// cancelStop cancels the pending stop of the tunnel with the given hostname. It returns false
+// if no stop was pending.
+func cancelStop(hostname string) bool {
+	pendingStopsMu.Lock()
+	defer pendingStopsMu.Unlock()
+
+	stop, ok := pendingStops[hostname]
+	if !ok {
+		return false
+	}
+
+	stop.timer.Stop()
+	stop.cancel()
+	delete(pendingStops, hostname)
+
+	return true
+}
+
+// cancelAllStops cancels every pending stop, leaving the tunnels running
+func cancelAllStops() {
+	pendingStopsMu.Lock()
+	defer pendingStopsMu.Unlock()
+
+	for hostname, stop := range pendingStops {
+		stop.timer.Stop()
+		stop.cancel()
+		delete(pendingStops, hostname)
+	}
+}
+
+// runTunnel starts a tunnel. If a tunnel for the same hostname is still running because its
+// container died within the stop delay, or because the tunnel is being moved to a replacement
+// container, that tunnel is kept and only pointed at the new origin.
+func runTunnel(ctx context.Context, tunnel *Tunnel) error {
+	hostname := tunnel.Config.Hostname
+
+	existing, err := GetTunnelForHost(hostname)
+	if cancelStop(hostname) && err == nil && !existing.InMaintenance() {
+		_, err = existing.UpdateOrigin(ctx, tunnel.Config)
+		return err
+	}
+
+	replaced := replacedTunnel(ctx, hostname, tunnel.Config.ContainerID)
+	if replaced != nil {
+		replaced.logger().Infof("Moving tunnel %s from container %s to %s", hostname, shortID(replaced.Config.ContainerID), shortID(tunnel.Config.ContainerID))
+
+		_, err = replaced.UpdateOrigin(ctx, tunnel.Config)
+		return err
+	}
+
+	return tunnel.Start(ctx)
+}
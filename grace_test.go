@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/spf13/afero"
+)
+
+func TestContainerStopDelay(t *testing.T) {
+	tunnelStopDelay = 10 * time.Second
+	defer func() { tunnelStopDelay = 0 }()
+
+	tests := map[string]time.Duration{
+		"":    10 * time.Second,
+		"30s": 30 * time.Second,
+		"0":   0,
+	}
+
+	for label, expected := range tests {
+		c := types.ContainerJSON{Config: &container.Config{Labels: map[string]string{}}}
+		if label != "" {
+			c.Config.Labels[heraStopDelay] = label
+		}
+
+		delay, err := containerStopDelay(c)
+		if err != nil || delay != expected {
+			t.Errorf("Unexpected delay for label %q, got %s and %v", label, delay, err)
+		}
+	}
+
+	c := types.ContainerJSON{Config: &container.Config{Labels: map[string]string{heraStopDelay: "soon"}}}
+	_, err := containerStopDelay(c)
+	if err == nil {
+		t.Error("Expected error for invalid label")
+	}
+}
+
+func TestScheduleStop(t *testing.T) {
+	stopped := make(chan struct{}, 10)
+
+	tunnel := newTunnel()
+	tunnel.Service.Commander = MockCommander{mockRun: func() ([]byte, error) {
+		stopped <- struct{}{}
+		return nil, nil
+	}}
+	registerTunnel(tunnel)
+	defer unregisterTunnel(tunnel)
+
+	scheduleStop(tunnel, time.Hour)
+	if !cancelStop(tunnel.Config.Hostname) {
+		t.Error("Expected stop to be pending")
+	}
+
+	if cancelStop(tunnel.Config.Hostname) {
+		t.Error("Expected stop to be cancelled")
+	}
+
+	scheduleStop(tunnel, time.Millisecond)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for tunnel to stop")
+	}
+
+	// the stop holds tunnelOperations until the tunnel is down
+	tunnelOperations.Lock()
+	tunnelOperations.Unlock()
+}
+
+func TestCancelAllStops(t *testing.T) {
+	stopped := make(chan struct{}, 10)
+
+	tunnel := newTunnel()
+	tunnel.Service.Commander = MockCommander{mockRun: func() ([]byte, error) {
+		stopped <- struct{}{}
+		return nil, nil
+	}}
+	registerTunnel(tunnel)
+	defer unregisterTunnel(tunnel)
+
+	scheduleStop(tunnel, 10*time.Millisecond)
+	cancelAllStops()
+
+	select {
+	case <-stopped:
+		t.Error("Expected tunnel to be left running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if cancelStop(tunnel.Config.Hostname) {
+		t.Error("Expected no stop to be pending")
+	}
+}
+
+func TestUpdateOrigin(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	restarts := 0
+
+	tunnel := newTunnel()
+	tunnel.Service.Commander = MockCommander{mockRun: func() ([]byte, error) {
+		restarts++
+		return nil, nil
+	}}
+	handoverTimeout = 10 * time.Millisecond
+	defer func() { handoverTimeout = 30 * time.Second }()
+
+	tunnelOperations.Lock()
+	defer tunnelOperations.Unlock()
+
+	restarted, err := tunnel.UpdateOrigin(context.Background(), &TunnelConfig{IP: "172.23.0.4", Port: "80", ContainerID: "def"})
+	if err != nil || restarted || restarts != 0 {
+		t.Errorf("Expected tunnel to be kept, got %t and %v", restarted, err)
+	}
+
+	if tunnel.Config.ContainerID != "def" {
+		t.Errorf("Unexpected container, got %s", tunnel.Config.ContainerID)
+	}
+
+	restarted, err = tunnel.UpdateOrigin(context.Background(), &TunnelConfig{IP: "172.23.0.9", Port: "80", ContainerID: "def"})
+	if err != nil || !restarted || restarts == 0 {
+		t.Errorf("Expected tunnel to be restarted, got %t and %v", restarted, err)
+	}
+
+	config, _ := afero.ReadFile(fs, tunnel.Service.ConfigFilePath())
+	if !strings.Contains(string(config), "url: 172.23.0.9:80") {
+		t.Errorf("Unexpected config, got %s", config)
+	}
+}
+
+func TestUpdateOriginWhileReadingStatus(t *testing.T) {
+	fs = afero.NewMemMapFs()
+
+	tunnel := newTunnel()
+	tunnel.Service.Commander = MockCommander{mockRun: func() ([]byte, error) { return nil, nil }}
+	handoverTimeout = 10 * time.Millisecond
+	defer func() { handoverTimeout = 30 * time.Second }()
+
+	tunnelOperations.Lock()
+	defer tunnelOperations.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 10; i++ {
+			tunnel.Status(context.Background())
+		}
+	}()
+
+	_, err := tunnel.UpdateOrigin(context.Background(), &TunnelConfig{IP: "172.23.0.9", Port: "80", ContainerID: "def"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	<-done
+
+	if status := tunnel.Status(context.Background()); status.IP != "172.23.0.9" || status.ContainerID != "def" {
+		t.Errorf("Unexpected origin, got %s of %s", status.IP, status.ContainerID)
+	}
+}
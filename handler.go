@@ -1,20 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"golang.org/x/net/publicsuffix"
 	"net"
+	"strconv"
 	"time"
 
-	"github.com/spf13/afero"
-
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/events"
 )
 
 const (
-	heraHostname = "hera.hostname"
-	heraPort     = "hera.port"
+	heraHostname    = "hera.hostname"
+	heraPort        = "hera.port"
+	heraHealthcheck = "hera.healthcheck"
+
+	eventHealthy   = "health_status: healthy"
+	eventUnhealthy = "health_status: unhealthy"
 )
 
 // A Handler is responsible for responding to container start and die events
@@ -32,78 +36,270 @@ func NewHandler(client *Client) *Handler {
 }
 
 // HandleEvent dispatches an event to the appropriate handler method depending on its status
-func (h *Handler) HandleEvent(event events.Message) {
+func (h *Handler) HandleEvent(ctx context.Context, event events.Message) {
+	tunnelOperations.Lock()
+	defer tunnelOperations.Unlock()
+
+	started := time.Now()
+	logger := withFields(dockerLog, Fields{fieldEvent: event.Status, fieldContainerID: shortID(event.ID)})
+
 	switch status := event.Status; status {
 	case "start":
-		err := h.handleStartEvent(event)
+		err := h.handleStartEvent(ctx, event)
 		if err != nil {
-			log.Error(err.Error())
+			logger.Errorf("%s", err)
 		}
 
 	case "die":
-		err := h.handleDieEvent(event)
+		err := h.handleDieEvent(ctx, event)
+		if err != nil {
+			logger.Errorf("%s", err)
+		}
+
+	case eventHealthy:
+		err := h.handleHealthyEvent(ctx, event)
+		if err != nil {
+			logger.Errorf("%s", err)
+		}
+
+	case eventUnhealthy:
+		err := h.handleUnhealthyEvent(ctx, event)
 		if err != nil {
-			log.Error(err.Error())
+			logger.Errorf("%s", err)
 		}
+
+	default:
+		return
 	}
+
+	metrics.EventDuration.Observe(time.Since(started), event.Status)
 }
 
 // HandleContainer allows immediate tunnel creation when hera is started by treating existing
-// containers as start events
-func (h *Handler) HandleContainer(id string) error {
-	event := events.Message{
-		ID: id,
+// containers as start events. Tunnels that are already running with the same config are adopted
+// rather than restarted. It returns the hostname of the tunnel and whether it was adopted,
+// restarted or started, or an empty hostname if the container is not configured for a tunnel.
+// Containers with a healthcheck that are not healthy yet, or whose origin fails its probe, are
+// left waiting.
+func (h *Handler) HandleContainer(ctx context.Context, id string) (string, string, error) {
+	tunnelOperations.Lock()
+	defer tunnelOperations.Unlock()
+
+	container, err := h.Client.Inspect(id)
+	if err != nil {
+		return "", "", err
+	}
+
+	hostname := getLabel(heraHostname, container)
+
+	if awaitingHealth(container) {
+		return hostname, outcomeWaiting, nil
 	}
 
-	err := h.handleStartEvent(event)
+	probe, err := parseProbe(container.Config.Labels)
 	if err != nil {
-		return err
+		return hostname, "", err
 	}
 
-	return nil
+	if probe != nil {
+		addr, err := containerOrigin(container)
+		if err == nil {
+			err = probe.Check(addr)
+		}
+
+		if err != nil {
+			watchProbe(h.originMonitor(container, probe), false)
+			return hostname, outcomeWaiting, nil
+		}
+	}
+
+	tunnel, err := h.tunnelToRun(container)
+	if err != nil || tunnel == nil {
+		return "", "", err
+	}
+
+	outcome, err := tunnel.Adopt(ctx)
+	if err != nil {
+		return tunnel.Config.Hostname, "", err
+	}
+
+	if probe != nil {
+		watchProbe(h.originMonitor(container, probe), true)
+	}
+
+	return tunnel.Config.Hostname, outcome, nil
 }
 
 // handleStartEvent inspects the container from a start event and creates a tunnel if the container
-// has been appropriately labeled and a certificate exists for its hostname
-func (h *Handler) handleStartEvent(event events.Message) error {
+// has been appropriately labeled and a certificate exists for its hostname. Containers with a
+// healthcheck get their tunnel once they are healthy.
+func (h *Handler) handleStartEvent(ctx context.Context, event events.Message) error {
 	container, err := h.Client.Inspect(event.ID)
 	if err != nil {
 		return err
 	}
 
-	hostname := getLabel(heraHostname, container)
-	port := getLabel(heraPort, container)
-	if hostname == "" || port == "" {
+	if awaitingHealth(container) {
+		withFields(dockerLog, Fields{fieldHostname: getLabel(heraHostname, container), fieldContainerID: shortID(container.ID)}).Infof("Waiting for %s to become healthy", container.ID[:12])
 		return nil
 	}
 
-	log.Infof("Container found, connecting to %s...", container.ID[:12])
+	return h.startTunnel(ctx, container)
+}
 
-	ip, err := h.resolveHostname(container)
+// startTunnel starts the tunnel for a labeled container. Containers with a probe get their
+// tunnel once their origin is ready. A container that replaces the container of a running
+// tunnel only takes the tunnel over once its origin accepts connections.
+func (h *Handler) startTunnel(ctx context.Context, container types.ContainerJSON) error {
+	hostname := getLabel(heraHostname, container)
+	if !isLabeled(container) {
+		return nil
+	}
+
+	origin := &TunnelConfig{}
+	err := parseProtocol(container.Config.Labels, origin)
 	if err != nil {
 		return err
 	}
 
-	cert, err := getCertificate(hostname)
+	probe, err := parseProbe(container.Config.Labels)
 	if err != nil {
 		return err
 	}
 
+	replacing := replacedTunnel(ctx, hostname, container.ID) != nil
+	if probe == nil && replacing && origin.hasNetworkOrigin() {
+		probe = readinessProbe()
+	}
+
+	if probe != nil {
+		withFields(dockerLog, Fields{fieldHostname: hostname, fieldContainerID: shortID(container.ID)}).Infof("Waiting for the origin of %s to pass its %s probe", container.ID[:12], probe)
+
+		monitor := h.originMonitor(container, probe)
+		monitor.StopWhenReady = replacing && getLabel(heraProbe, container) == ""
+		watchProbe(monitor, false)
+
+		return nil
+	}
+
+	tunnel, err := h.tunnelToRun(container)
+	if err != nil || tunnel == nil {
+		return err
+	}
+
+	return runTunnel(ctx, tunnel)
+}
+
+// originMonitor returns a monitor that probes the origin of a container, starting its tunnel
+// when the origin is ready and stopping the tunnel when the origin is down
+func (h *Handler) originMonitor(container types.ContainerJSON, probe *Probe) *ProbeMonitor {
+	hostname := getLabel(heraHostname, container)
+
+	monitor := NewProbeMonitor(hostname, probe, func() (string, error) {
+		return containerOrigin(container)
+	})
+	monitor.ContainerID = container.ID
+
+	monitor.Ready = func(ctx context.Context) error {
+		tunnel, err := h.tunnelToRun(container)
+		if err != nil || tunnel == nil {
+			return err
+		}
+
+		return runTunnel(ctx, tunnel)
+	}
+
+	monitor.Down = func(ctx context.Context) error {
+		tunnel, err := GetTunnelForHost(hostname)
+		if err != nil || tunnel.Config.ContainerID != container.ID {
+			return nil
+		}
+
+		return takeDown(ctx, tunnel)
+	}
+
+	return monitor
+}
+
+// tunnelFromContainer returns a new tunnel for an inspected container, or nil if the container
+// has not been labeled for a tunnel
+func (h *Handler) tunnelFromContainer(container types.ContainerJSON) (*Tunnel, error) {
+	config, err := h.tunnelConfigFromContainer(container)
+	if err != nil || config == nil {
+		return nil, err
+	}
+
+	cert, err := getCertificate(config.Hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTunnel(config, cert), nil
+}
+
+// tunnelToRun returns the tunnel to run for an inspected container like tunnelFromContainer,
+// notifying webhooks if no certificate exists for its hostname
+func (h *Handler) tunnelToRun(container types.ContainerJSON) (*Tunnel, error) {
+	config, err := h.tunnelConfigFromContainer(container)
+	if err != nil || config == nil {
+		return nil, err
+	}
+
+	cert, err := getCertificate(config.Hostname)
+	if err != nil {
+		notify(eventCertificateMissing, config, err)
+		return nil, err
+	}
+
+	return NewTunnel(config, cert), nil
+}
+
+// tunnelConfigFromContainer returns the tunnel config for an inspected container, or nil if the
+// container has not been labeled for a tunnel
+func (h *Handler) tunnelConfigFromContainer(container types.ContainerJSON) (*TunnelConfig, error) {
+	hostname := getLabel(heraHostname, container)
+	if !isLabeled(container) {
+		return nil, nil
+	}
+
 	config := &TunnelConfig{
-		IP:       ip,
-		Hostname: hostname,
-		Port:     port,
+		Hostname:    hostname,
+		Port:        getLabel(heraPort, container),
+		ContainerID: container.ID,
 	}
 
-	tunnel := NewTunnel(config, cert)
-	tunnel.Start()
+	err := parseProtocol(container.Config.Labels, config)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	config.Origin, err = parseOriginOptions(container.Config.Labels, config.Protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Settings, err = parseCloudflaredSettings(container.Config.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	withFields(dockerLog, Fields{fieldHostname: hostname, fieldContainerID: shortID(container.ID)}).Infof("Container found, connecting to %s...", container.ID[:12])
+
+	if config.hasNetworkOrigin() {
+		config.IP, err = h.resolveHostname(container)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
 }
 
-// handleDieEvent inspects the container from a die event and stops the tunnel if one exists.
+// handleDieEvent inspects the container from a die event and stops the tunnel if one exists, or
+// points it at the maintenance page if maintenance pages are enabled. The tunnel is kept running
+// for the stop delay first in case the container starts again.
 // An error is returned if a tunnel cannot be found or if the tunnel fails to stop
-func (h *Handler) handleDieEvent(event events.Message) error {
+func (h *Handler) handleDieEvent(ctx context.Context, event events.Message) error {
 	container, err := h.Client.Inspect(event.ID)
 	if err != nil {
 		return err
@@ -114,12 +310,30 @@ func (h *Handler) handleDieEvent(event events.Message) error {
 		return nil
 	}
 
+	stopProbing(hostname, container.ID)
+
 	tunnel, err := GetTunnelForHost(hostname)
 	if err != nil {
 		return err
 	}
 
-	err = tunnel.Stop()
+	if tunnel.Config.ContainerID != container.ID {
+		tunnel.logger().Infof("Container %s has stopped, tunnel %s has moved to %s", container.ID[:12], hostname, shortID(tunnel.Config.ContainerID))
+		return nil
+	}
+
+	delay, err := containerStopDelay(container)
+	if err != nil {
+		tunnel.logger().Errorf("%s", err)
+	}
+
+	if delay > 0 && !tunnel.InMaintenance() {
+		tunnel.logger().Infof("Container %s has stopped, keeping tunnel %s for %s", container.ID[:12], hostname, delay)
+		scheduleStop(tunnel, delay)
+		return nil
+	}
+
+	err = takeDown(ctx, tunnel)
 	if err != nil {
 		return err
 	}
@@ -127,27 +341,150 @@ func (h *Handler) handleDieEvent(event events.Message) error {
 	return nil
 }
 
-// resolveHostname returns the IP address of a container from its hostname.
-// An error is returned if the hostname cannot be resolved after five attempts.
+// handleHealthyEvent inspects the container from a healthy event and starts its tunnel
+func (h *Handler) handleHealthyEvent(ctx context.Context, event events.Message) error {
+	container, err := h.Client.Inspect(event.ID)
+	if err != nil {
+		return err
+	}
+
+	return h.startHealthyTunnel(ctx, container)
+}
+
+// startHealthyTunnel starts the tunnel for a container that has become healthy, unless its
+// tunnel is already running with the container as its origin. A tunnel serving the maintenance
+// page is pointed back at the container.
+func (h *Handler) startHealthyTunnel(ctx context.Context, container types.ContainerJSON) error {
+	if !healthGated(container) {
+		return nil
+	}
+
+	existing, err := GetTunnelForHost(getLabel(heraHostname, container))
+	if err == nil && existing.Config.ContainerID == container.ID && !existing.InMaintenance() {
+		running, err := existing.Service.IsRunning(ctx)
+		if err == nil && running {
+			return nil
+		}
+	}
+
+	return h.startTunnel(ctx, container)
+}
+
+// handleUnhealthyEvent inspects the container from an unhealthy event and takes down its tunnel
+func (h *Handler) handleUnhealthyEvent(ctx context.Context, event events.Message) error {
+	container, err := h.Client.Inspect(event.ID)
+	if err != nil {
+		return err
+	}
+
+	return h.takeDownUnhealthyTunnel(ctx, container)
+}
+
+// takeDownUnhealthyTunnel stops the tunnel for a container that has become unhealthy, or points
+// it at the maintenance page. The tunnel is started again when the container is healthy.
+func (h *Handler) takeDownUnhealthyTunnel(ctx context.Context, container types.ContainerJSON) error {
+	if !healthGated(container) {
+		return nil
+	}
+
+	stopProbing(getLabel(heraHostname, container), container.ID)
+
+	tunnel, err := GetTunnelForHost(getLabel(heraHostname, container))
+	if err != nil || tunnel.Config.ContainerID != container.ID {
+		return nil
+	}
+
+	tunnel.logger().Warningf("Container %s is unhealthy", container.ID[:12])
+
+	return takeDown(ctx, tunnel)
+}
+
+// healthGated returns true if the tunnel for a labeled container follows its Docker
+// healthcheck, which is the case unless the container has no healthcheck or opts out with
+// the hera.healthcheck label
+func healthGated(container types.ContainerJSON) bool {
+	if !isLabeled(container) {
+		return false
+	}
+
+	if container.State == nil || container.State.Health == nil || container.State.Health.Status == types.NoHealthcheck {
+		return false
+	}
+
+	enabled, err := strconv.ParseBool(getLabel(heraHealthcheck, container))
+
+	return err != nil || enabled
+}
+
+// awaitingHealth returns true if the tunnel for a container waits until the container is healthy
+func awaitingHealth(container types.ContainerJSON) bool {
+	return healthGated(container) && container.State.Health.Status != types.Healthy
+}
+
+// isLabeled returns true if a container has the labels needed for a tunnel: a hostname, and a
+// port unless its origin is a Unix socket or a bastion
+func isLabeled(container types.ContainerJSON) bool {
+	if getLabel(heraHostname, container) == "" {
+		return false
+	}
+
+	if getLabel(heraPort, container) != "" || getLabel(heraProtocol, container) == protocolUnix {
+		return true
+	}
+
+	bastion, _ := strconv.ParseBool(getLabel(heraBastion, container))
+
+	return bastion
+}
+
+// containerOrigin returns the address of the origin of a container from its hostname and port label
+func containerOrigin(container types.ContainerJSON) (string, error) {
+	resolved, err := net.LookupHost(container.Config.Hostname)
+	if err != nil {
+		return "", err
+	}
+
+	ip, err := selectAddress(resolved, addressFamily)
+	if err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(ip, getLabel(heraPort, container)), nil
+}
+
+// resolveHostname returns the IP address of a container from its hostname, preferring
+// addresses of the configured address family.
+// An error is returned if the hostname cannot be resolved after five attempts, or after one
+// attempt for containers with a probe, which are only resolved once their origin is ready.
 func (h *Handler) resolveHostname(container types.ContainerJSON) (string, error) {
 	var resolved []string
 	var err error
 
+	hostname := getLabel(heraHostname, container)
 	attempts := 0
 	maxAttempts := 5
 
+	if getLabel(heraProbe, container) != "" {
+		maxAttempts = 1
+	}
+
 	for attempts < maxAttempts {
 		attempts++
 		resolved, err = net.LookupHost(container.Config.Hostname)
 
+		if err != nil && attempts == maxAttempts {
+			break
+		}
+
 		if err != nil {
 			time.Sleep(2 * time.Second)
-			log.Infof("Unable to connect, retrying... (%d/%d)", attempts, maxAttempts)
+			dockerLog.Infof("Unable to connect, retrying... (%d/%d)", attempts, maxAttempts)
+			metrics.ResolutionRetries.Inc(hostname)
 
 			continue
 		}
 
-		return resolved[0], nil
+		return selectAddress(resolved, addressFamily)
 	}
 
 	return "", fmt.Errorf("Unable to connect to %s", container.ID[:12])
@@ -166,17 +503,7 @@ func getLabel(name string, container types.ContainerJSON) string {
 // getCertificate returns a Certificate for a given hostname.
 // An error is returned if the root hostname cannot be parsed or if the certificate cannot be found.
 func getCertificate(hostname string) (*Certificate, error) {
-	rootHostname, err := getRootDomain(hostname)
-	if err != nil {
-		return nil, err
-	}
-
-	cert, err := FindCertificateForHost(rootHostname, afero.NewOsFs())
-	if err != nil {
-		return nil, err
-	}
-
-	return cert, nil
+	return findCertificate(hostname, fs)
 }
 
 // getRootDomain returns the root domain for a given hostname
@@ -1,7 +1,20 @@
 package main
 
 import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/spf13/afero"
 )
 
 func TestGetRootDomain(t *testing.T) {
@@ -22,4 +35,191 @@ func TestGetRootDomain(t *testing.T) {
 			t.Errorf("Unexpected domain, got %s", actual)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestHealthGated(t *testing.T) {
+	labeled := map[string]string{heraHostname: "site.tld", heraPort: "80"}
+	optedOut := map[string]string{heraHostname: "site.tld", heraPort: "80", heraHealthcheck: "false"}
+
+	tests := []struct {
+		labels   map[string]string
+		health   *types.Health
+		gated    bool
+		awaiting bool
+	}{
+		{labeled, nil, false, false},
+		{labeled, &types.Health{Status: types.Starting}, true, true},
+		{labeled, &types.Health{Status: types.Unhealthy}, true, true},
+		{labeled, &types.Health{Status: types.Healthy}, true, false},
+		{optedOut, &types.Health{Status: types.Starting}, false, false},
+		{map[string]string{}, &types.Health{Status: types.Starting}, false, false},
+	}
+
+	for _, test := range tests {
+		c := types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{State: &types.ContainerState{Health: test.health}},
+			Config:            &container.Config{Labels: test.labels},
+		}
+
+		if healthGated(c) != test.gated || awaitingHealth(c) != test.awaiting {
+			t.Errorf("Unexpected gating for labels %v and health %v", test.labels, test.health)
+		}
+	}
+}
+
+func TestTunnelFromContainerDoesNotNotify(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		events = append(events, r.Header.Get("X-Hera-Event"))
+	}))
+	defer server.Close()
+
+	var err error
+	notifier, err = NewNotifier([]*Webhook{{URL: server.URL}}, 0, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { notifier = nil }()
+
+	c := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{ID: "9b3d4f2a1c7e5b8d"},
+		Config: &container.Config{Labels: map[string]string{
+			heraHostname: "missing-certificate.tld",
+			heraProtocol: protocolUnix,
+			heraSocket:   "/sockets/app.sock",
+		}},
+	}
+
+	handler := &Handler{}
+
+	_, err = handler.tunnelFromContainer(c)
+	if err == nil {
+		t.Fatal("Expected error for missing certificate")
+	}
+
+	notifier.Wait(time.Second)
+	mu.Lock()
+	count := len(events)
+	mu.Unlock()
+
+	if count != 0 {
+		t.Errorf("Expected no notifications from tunnelFromContainer, got %d", count)
+	}
+
+	_, err = handler.tunnelToRun(c)
+	if err == nil {
+		t.Fatal("Expected error for missing certificate")
+	}
+
+	notifier.Wait(time.Second)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(events) != 1 {
+		t.Errorf("Expected one notification from tunnelToRun, got %v", events)
+	}
+}
+
+// fakeS6 puts scripts standing in for the s6 commands first on the PATH, returning a function
+// that restores it
+func fakeS6(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "hera-s6")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scripts := map[string]string{
+		"s6-svscanctl": "exit 0",
+		"s6-svc":       "exit 0",
+		"s6-svstat":    "echo true true 12 -1 NA 300",
+	}
+
+	for name, script := range scripts {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestHealthyAfterMaintenance(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	afero.WriteFile(fs, CertificatePath+"/site.tld.pem", []byte("certificate"), 0644)
+
+	maintenance = NewMaintenancePages("127.0.0.1:8081", "", fs)
+	defer func() { maintenance = nil }()
+
+	// the tunnel started for the healthy container runs the s6 commands on the PATH
+	defer fakeS6(t)()
+
+	c := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    "9b3d4f2a1c7e5b8d",
+			State: &types.ContainerState{Health: &types.Health{Status: types.Unhealthy}},
+		},
+		Config: &container.Config{Labels: map[string]string{
+			heraHostname: "site.tld",
+			heraProtocol: protocolUnix,
+			heraSocket:   "/sockets/app.sock",
+		}},
+	}
+
+	handler := &Handler{}
+
+	tunnel, err := handler.tunnelFromContainer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer unregisterTunnel(tunnel)
+
+	err = tunnel.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.release()
+
+	err = handler.takeDownUnhealthyTunnel(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !tunnel.InMaintenance() {
+		t.Fatal("Expected unhealthy container to get the maintenance page")
+	}
+
+	c.State.Health.Status = types.Healthy
+
+	err = handler.startHealthyTunnel(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registered, err := GetTunnelForHost("site.tld")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer registered.release()
+
+	if registered.InMaintenance() {
+		t.Error("Expected healthy container to be served again")
+	}
+
+	config, _ := afero.ReadFile(fs, registered.Service.ConfigFilePath())
+	if !strings.Contains(string(config), "url: unix:/sockets/app.sock") {
+		t.Errorf("Unexpected config, got %s", config)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// handoverSuffix names the service that serves a hostname while its tunnel restarts
+	handoverSuffix = "@handover"
+
+	// handoverMetricsAddr lets cloudflared pick a free port for the metrics server of a handover
+	// service so that it does not clash with the tunnel it stands in for
+	handoverMetricsAddr = "127.0.0.1:0"
+)
+
+var (
+	// handoverTimeout is how long a handover or restarted tunnel service may take to connect
+	handoverTimeout = 30 * time.Second
+
+	// handingOver holds the hostnames of tunnels that are being handed over. A handover
+	// releases tunnelOperations while it waits for cloudflared to connect, so that other
+	// tunnels can be operated on in the meantime.
+	handingOver  = make(map[string]bool)
+	handoverDone = sync.NewCond(&tunnelOperations)
+)
+
+// awaitHandover waits until the tunnel for a hostname is no longer being handed over. It is
+// called while holding tunnelOperations, which is released while waiting.
+func awaitHandover(hostname string) {
+	for handingOver[hostname] {
+		handoverDone.Wait()
+	}
+}
+
+// replacedTunnel returns the running tunnel for a hostname if it belongs to a container other
+// than the one with the given ID, or nil otherwise
+func replacedTunnel(ctx context.Context, hostname, containerID string) *Tunnel {
+	existing, err := GetTunnelForHost(hostname)
+	if err != nil || existing.Config.ContainerID == containerID || existing.InMaintenance() {
+		return nil
+	}
+
+	running, err := existing.Service.IsRunning(ctx)
+	if err != nil || !running {
+		return nil
+	}
+
+	return existing
+}
+
+// restartWithHandover restarts the tunnel service without leaving the hostname unserved. A
+// handover service with the same config is connected first and serves the hostname while the
+// tunnel service restarts, and is stopped once the tunnel service has connected again. The
+// tunnel service is restarted as usual if the handover service fails to connect.
+// It is called while holding tunnelOperations, which is released until the handover is done.
+// Operations on the same hostname wait for the handover with awaitHandover.
+func (t *Tunnel) restartWithHandover(ctx context.Context) error {
+	hostname := t.Config.Hostname
+
+	handingOver[hostname] = true
+	tunnelOperations.Unlock()
+
+	defer func() {
+		tunnelOperations.Lock()
+		delete(handingOver, hostname)
+		handoverDone.Broadcast()
+	}()
+
+	handover, err := t.startHandover(ctx)
+	if err != nil {
+		t.logger().Warningf("Unable to hand over %s, restarting without handover: %s", t.Config.Hostname, err)
+		return t.Service.Restart(ctx)
+	}
+
+	err = waitConnected(ctx, t.Service.LogFilePath(), handoverTimeout, t.Service.Restart)
+
+	t.logger().Infof("Stopping handover for %s", t.Config.Hostname)
+	handover.removeHandover(ctx)
+
+	return err
+}
+
+// startHandover starts a handover service for the tunnel and waits for it to connect
+func (t *Tunnel) startHandover(ctx context.Context) (*Tunnel, error) {
+	config := *t.Config
+	config.MetricsAddr = handoverMetricsAddr
+
+	handover := NewTunnel(&config, t.Certificate)
+	handover.Service = NewService(t.Config.Hostname + handoverSuffix)
+	handover.Service.Commander = t.Service.Commander
+
+	t.logger().Infof("Starting handover for %s", t.Config.Hostname)
+
+	err := handover.prepareService()
+	if err == nil {
+		err = waitConnected(ctx, handover.Service.LogFilePath(), handoverTimeout, handover.startService)
+	}
+
+	if err != nil {
+		handover.removeHandover(ctx)
+		return nil, err
+	}
+
+	return handover, nil
+}
+
+// removeHandover stops a handover service and removes its service directory and log so that s6
+// no longer supervises it. The directory is removed even if the service fails to stop, which
+// has s6 stop it instead.
+func (t *Tunnel) removeHandover(ctx context.Context) {
+	err := t.Service.Stop(ctx)
+	if err != nil {
+		t.logger().Errorf("Unable to stop handover %s: %s", t.Service.Hostname, err)
+	}
+
+	err = t.Service.Remove(ctx)
+	if err != nil {
+		t.logger().Errorf("Unable to remove handover %s: %s", t.Service.Hostname, err)
+	}
+}
+
+// waitConnected calls start and waits until cloudflared logs a registered connection to the log
+// at path.
+// An error is returned if start fails, cloudflared logs a fatal error, no connection is
+// registered within the timeout or the context is done.
+func waitConnected(ctx context.Context, path string, timeout time.Duration, start func(context.Context) error) error {
+	result := make(chan error, 1)
+
+	watcher := NewLogWatcher(path, fs)
+	watcher.Start(func(line string) {
+		var err error
+
+		switch kind, message := parseLogLine(line); kind {
+		case logEventRegistered:
+		case logEventFatal:
+			err = errors.New(message)
+		default:
+			return
+		}
+
+		select {
+		case result <- err:
+		default:
+		}
+	})
+	defer watcher.Stop()
+
+	err := start(ctx)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(timeout):
+		return fmt.Errorf("No connection registered in %s within %s", path, timeout)
+	}
+}
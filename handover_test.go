@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestRestartWithHandover(t *testing.T) {
+	fs = afero.NewMemMapFs()
+
+	tunnel := newTunnel()
+	handover := NewService(tunnel.Config.Hostname + handoverSuffix)
+	handoverLog := handover.LogFilePath()
+	configWritten := false
+
+	// every command connects the tunnel service and the handover service while it exists
+	tunnel.Service.Commander = MockCommander{mockRun: func() ([]byte, error) {
+		logs := []string{tunnel.Service.LogFilePath()}
+		if exists, _ := afero.Exists(fs, handover.ConfigFilePath()); exists {
+			configWritten = true
+			logs = append(logs, handoverLog)
+		}
+
+		for _, path := range logs {
+			file, err := fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err == nil {
+				file.WriteString("INF Connected to SEA\n")
+				file.Close()
+			}
+		}
+
+		return nil, nil
+	}}
+
+	tunnelOperations.Lock()
+	err := tunnel.restartWithHandover(context.Background())
+	tunnelOperations.Unlock()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !configWritten {
+		t.Error("Expected handover config to be written")
+	}
+
+	for _, path := range []string{handover.servicePath(), handoverLog} {
+		if exists, _ := afero.Exists(fs, path); exists {
+			t.Errorf("Expected %s to be removed after the handover", path)
+		}
+	}
+}
+
+func TestWaitConnected(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	path := "/var/log/hera/site.tld.log"
+
+	err := waitConnected(context.Background(), path, 5*time.Second, func(context.Context) error {
+		return afero.WriteFile(fs, path, []byte("ERR Unauthorized: Failed to get tunnel\n"), 0644)
+	})
+	if err == nil {
+		t.Error("Expected fatal error to be returned")
+	}
+
+	err = waitConnected(context.Background(), path, 10*time.Millisecond, func(context.Context) error { return nil })
+	if err == nil {
+		t.Error("Expected timeout error")
+	}
+}
+
+func TestHandoverReleasesTunnelOperations(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	handoverTimeout = 10 * time.Millisecond
+	defer func() { handoverTimeout = 30 * time.Second }()
+
+	started := make(chan struct{})
+	resume := make(chan struct{})
+	var once sync.Once
+
+	tunnel := newTunnel()
+	tunnel.Service.Commander = MockCommander{mockRun: func() ([]byte, error) {
+		once.Do(func() {
+			close(started)
+			<-resume
+		})
+		return nil, nil
+	}}
+
+	done := make(chan error, 1)
+	go func() {
+		tunnelOperations.Lock()
+		defer tunnelOperations.Unlock()
+
+		_, err := tunnel.UpdateOrigin(context.Background(), &TunnelConfig{IP: "172.23.0.9", Port: "80"})
+		done <- err
+	}()
+
+	<-started
+
+	// other tunnels can be operated on while the handover waits
+	tunnelOperations.Lock()
+
+	if !handingOver[tunnel.Config.Hostname] {
+		t.Error("Expected tunnel to be handed over")
+	}
+
+	close(resume)
+	awaitHandover(tunnel.Config.Hostname)
+
+	if handingOver[tunnel.Config.Hostname] {
+		t.Error("Expected handover to be done")
+	}
+
+	tunnelOperations.Unlock()
+	<-done
+}
@@ -1,11 +1,19 @@
 package main
 
 import (
+    "context"
+    "fmt"
     "io"
+    "time"
 
+    "github.com/docker/docker/api/types/events"
     "github.com/spf13/afero"
 )
 
+const (
+	eventStreamReconnectDelay = 5 * time.Second
+)
+
 // Listener holds config for an event listener and is used to listen for container events
 type Listener struct {
 	Client *Client
@@ -16,7 +24,7 @@ type Listener struct {
 func NewListener() (*Listener, error) {
 	client, err := NewClient()
 	if err != nil {
-		log.Errorf("Unable to connect to Docker: %s", err)
+		dockerLog.Errorf("Unable to connect to Docker: %s", err)
 		return nil, err
 	}
 
@@ -28,40 +36,220 @@ func NewListener() (*Listener, error) {
 	return listener, nil
 }
 
-// Revive revives tunnels for currently running containers
-func (l *Listener) Revive() error {
+// ReviveReport lists the hostnames of revived tunnels by how they were revived
+type ReviveReport struct {
+	Adopted   []string `json:"adopted"`
+	Restarted []string `json:"restarted"`
+	Started   []string `json:"started"`
+	Waiting   []string `json:"waiting"`
+	Stopped   []string `json:"stopped"`
+	Failed    []string `json:"failed"`
+}
+
+// String summarizes the report for logging
+func (r *ReviveReport) String() string {
+	summary := fmt.Sprintf("adopted %d %v, restarted %d %v, started %d %v",
+		len(r.Adopted), r.Adopted, len(r.Restarted), r.Restarted, len(r.Started), r.Started)
+
+	if len(r.Waiting) > 0 {
+		summary = fmt.Sprintf("%s, waiting for healthy %d %v", summary, len(r.Waiting), r.Waiting)
+	}
+
+	if len(r.Stopped) > 0 {
+		summary = fmt.Sprintf("%s, stopped %d %v", summary, len(r.Stopped), r.Stopped)
+	}
+
+	if len(r.Failed) > 0 {
+		summary = fmt.Sprintf("%s, failed %d %v", summary, len(r.Failed), r.Failed)
+	}
+
+	return summary
+}
+
+// owns returns true if the tunnel belongs to a running container in the report, which is the
+// case if its hostname is listed with any outcome, including containers waiting to become
+// healthy and containers that failed to revive, or if its container failed to be inspected
+func (r *ReviveReport) owns(tunnel *Tunnel) bool {
+	config := tunnel.config()
+
+	for _, list := range [][]string{r.Adopted, r.Restarted, r.Started, r.Waiting, r.Failed} {
+		for _, h := range list {
+			if h == config.Hostname || (config.ContainerID != "" && h == shortID(config.ContainerID)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Revive revives tunnels for currently running containers, adopting tunnels that are already
+// running with the same config. Containers that fail to revive are logged and listed in the
+// report. Reviving stops early if the context is done.
+// An error is returned if the containers cannot be listed.
+func (l *Listener) Revive(ctx context.Context) (*ReviveReport, error) {
+	report := &ReviveReport{}
+
 	handler := NewHandler(l.Client)
 	containers, err := l.Client.ListContainers()
 	if err != nil {
-		return err
+		return report, err
 	}
 
 	for _, c := range containers {
-		err := handler.HandleContainer(c.ID)
+		if ctx.Err() != nil {
+			return report, nil
+		}
+
+		hostname, outcome, err := handler.HandleContainer(ctx, c.ID)
+		if err != nil {
+			dockerLog.Errorf("Unable to revive tunnel for %s: %s", c.ID[:12], err)
+
+			if hostname == "" {
+				hostname = c.ID[:12]
+			}
+			report.Failed = append(report.Failed, hostname)
+
+			continue
+		}
+
+		switch outcome {
+		case outcomeAdopted:
+			report.Adopted = append(report.Adopted, hostname)
+		case outcomeRestarted:
+			report.Restarted = append(report.Restarted, hostname)
+		case outcomeStarted:
+			report.Started = append(report.Started, hostname)
+		case outcomeWaiting:
+			report.Waiting = append(report.Waiting, hostname)
+		}
+	}
+
+	return report, nil
+}
+
+// Reconcile brings the tunnels in line with the running containers. Tunnels are revived for
+// every running container and tunnels whose container is gone or no longer labeled are
+// stopped, unless they are serving the maintenance page. Tunnels of containers that are
+// waiting to become healthy or failed to revive are left running.
+func (l *Listener) Reconcile(ctx context.Context) (*ReviveReport, error) {
+	report, err := l.Revive(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	for _, tunnel := range AllTunnels() {
+		hostname := tunnel.Config.Hostname
+		if report.owns(tunnel) {
+			continue
+		}
+
+		running, err := tunnel.Service.IsRunning(ctx)
+		if err != nil || !running || tunnel.InMaintenance() {
+			continue
+		}
+
+		tunnelOperations.Lock()
+		err = tunnel.Stop(ctx)
+		tunnelOperations.Unlock()
+
 		if err != nil {
-			return err
+			dockerLog.Errorf("Unable to stop tunnel %s: %s", hostname, err)
+			report.Failed = append(report.Failed, hostname)
+			continue
+		}
+
+		report.Stopped = append(report.Stopped, hostname)
+	}
+
+	return report, nil
+}
+
+// CertificateReport lists the hostnames of tunnels by the outcome of reloading their certificates
+type CertificateReport struct {
+	Restarted []string `json:"restarted"`
+	Unchanged []string `json:"unchanged"`
+	Failed    []string `json:"failed"`
+}
+
+// ReloadCertificates finds the certificate of every tunnel again and restarts the tunnels
+// whose certificate has changed
+func (l *Listener) ReloadCertificates(ctx context.Context) *CertificateReport {
+	report := &CertificateReport{}
+
+	for _, tunnel := range AllTunnels() {
+		hostname := tunnel.Config.Hostname
+
+		tunnelOperations.Lock()
+		restarted, err := tunnel.ReloadCertificate(ctx)
+		tunnelOperations.Unlock()
+
+		switch {
+		case err != nil:
+			dockerLog.Errorf("Unable to reload certificate for %s: %s", hostname, err)
+			report.Failed = append(report.Failed, hostname)
+		case restarted:
+			report.Restarted = append(report.Restarted, hostname)
+		default:
+			report.Unchanged = append(report.Unchanged, hostname)
 		}
 	}
 
-	return nil
+	return report
 }
 
-// Listen listens for container events to be handled
-func (l *Listener) Listen() {
-	log.Info("Hera is listening")
+// Listen listens for container events to be handled until the context is done.
+// An event that is being handled when the context is done is handled to completion. When the
+// event stream drops, it is opened again from the last event handled so that no events are
+// missed while reconnecting.
+func (l *Listener) Listen(ctx context.Context) {
+	dockerLog.Info("Hera is listening")
 
 	handler := NewHandler(l.Client)
-	messages, errs := l.Client.Events()
+	since := time.Now()
+	messages, errs := l.Client.Events(ctx, time.Time{})
 
 	for {
 		select {
+		case <-ctx.Done():
+			dockerLog.Info("Hera has stopped listening")
+			return
+
 		case event := <-messages:
-			handler.HandleEvent(event)
+			// the context only ends the listening, so a tunnel is not left down halfway
+			// through a restart
+			handler.HandleEvent(context.Background(), event)
+			since = nextEventTime(event)
 
 		case err := <-errs:
+			if ctx.Err() != nil {
+				continue
+			}
+
 			if err != nil && err != io.EOF {
-				log.Error(err.Error())
+				dockerLog.Error(err.Error())
 			}
+
+			dockerLog.Infof("Docker event stream closed, reconnecting in %s", eventStreamReconnectDelay)
+
+			select {
+			case <-ctx.Done():
+				continue
+			case <-time.After(eventStreamReconnectDelay):
+			}
+
+			metrics.EventStreamReconnects.Inc()
+			messages, errs = l.Client.Events(ctx, since)
 		}
 	}
 }
+
+// nextEventTime returns the time to resume the event stream from after an event. Events
+// without a timestamp in nanoseconds are resumed from the same second, which may repeat them.
+func nextEventTime(event events.Message) time.Time {
+	if event.TimeNano != 0 {
+		return time.Unix(0, event.TimeNano+1)
+	}
+
+	return time.Unix(event.Time, 0)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+func TestReviveReportOwns(t *testing.T) {
+	report := &ReviveReport{
+		Adopted: []string{"adopted.tld"},
+		Waiting: []string{"waiting.tld"},
+		Failed:  []string{"failed.tld", "9b3d4f2a1c7e"},
+	}
+
+	tests := map[*TunnelConfig]bool{
+		{Hostname: "adopted.tld"}: true,
+		{Hostname: "waiting.tld"}: true,
+		{Hostname: "failed.tld"}:  true,
+		{Hostname: "uninspected.tld", ContainerID: "9b3d4f2a1c7e5b8d"}: true,
+		{Hostname: "gone.tld", ContainerID: "0123456789abcdef"}:        false,
+	}
+
+	for config, expected := range tests {
+		if owned := report.owns(NewTunnel(config, nil)); owned != expected {
+			t.Errorf("Expected %t for %s, got %t", expected, config.Hostname, owned)
+		}
+	}
+}
+
+func TestNextEventTime(t *testing.T) {
+	event := events.Message{Time: 1555000000, TimeNano: 1555000000123456789}
+	if next := nextEventTime(event); next.UnixNano() != 1555000000123456790 {
+		t.Errorf("Expected stream to resume after the event, got %s", next)
+	}
+
+	event = events.Message{Time: 1555000000}
+	if next := nextEventTime(event); next.Unix() != 1555000000 {
+		t.Errorf("Expected stream to resume from the second of the event, got %s", next)
+	}
+}
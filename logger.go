@@ -1,32 +1,345 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	logging "github.com/op/go-logging"
+	"github.com/spf13/afero"
 )
 
 const (
 	LogDir = "/var/log/hera"
+
+	logFormatText = "text"
+	logFormatJSON = "json"
+
+	fieldHostname    = "hostname"
+	fieldContainerID = "container_id"
+	fieldEvent       = "event"
+	fieldTunnelState = "tunnel_state"
+)
+
+// Each module has its own logger so that its level can be configured separately
+var (
+	log       = logging.MustGetLogger("hera")
+	dockerLog = logging.MustGetLogger("docker")
+	tunnelLog = logging.MustGetLogger("tunnel")
+	apiLog    = logging.MustGetLogger("api")
 )
 
-func InitLogger(name string) {
-	log := logging.MustGetLogger(name)
-	logPath := filepath.Join(LogDir, name)
+// logModules are the names of the loggers that levels can be configured for
+var logModules = []string{"hera", "docker", "tunnel", "api"}
+
+// redactions replace secrets in log messages, such as the paths of certificates and tokens
+var redactions = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`-----BEGIN [A-Z ]+-----[\s\S]*?-----END [A-Z ]+-----`), "[REDACTED]"},
+	{regexp.MustCompile(regexp.QuoteMeta(CertificatePath) + `/[^\s"',]+`), CertificatePath + "/[REDACTED]"},
+	{regexp.MustCompile(`(?i)(token|secret|password)(["']?\s*[:=]\s*["']?)[^\s"',]+`), "${1}${2}[REDACTED]"},
+}
+
+// LogOptions configures the format, levels and redaction of log output
+type LogOptions struct {
+	Format string
+	Level  string
+	Levels map[string]string
+	Redact bool
+
+	// Rotation rotates the log file, which is never rotated when it is nil
+	Rotation *RotationPolicy
+
+	// SyslogAddr is the address of a syslog server to also log to, if any
+	SyslogAddr     string
+	SyslogFacility string
+}
+
+// InitLogger logs to stderr, to a file in LogDir named after the given name and to syslog if
+// configured.
+// An error is returned if the options are invalid, in which case nothing is changed.
+func InitLogger(name string, options *LogOptions) error {
+	if options.Format != logFormatText && options.Format != logFormatJSON {
+		return fmt.Errorf("Invalid log format %q", options.Format)
+	}
+
+	backends := []logging.Backend{
+		NewLogBackend(os.Stderr, options.Format, false, options.Redact),
+	}
+
+	if options.SyslogAddr != "" {
+		writer, err := NewSyslogWriter(options.SyslogAddr, options.SyslogFacility)
+		if err != nil {
+			return err
+		}
 
-	stderrBackend := logging.NewLogBackend(os.Stderr, "", 0)
-	strderrBackendFormat := logging.MustStringFormatter(`[%{level}] %{message}`)
-	strderrBackendFormatter := logging.NewBackendFormatter(stderrBackend, strderrBackendFormat)
+		backends = append(backends, NewSyslogBackend(writer, name, options.Redact))
+	}
 
-	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	logFile, err := openLogFile(filepath.Join(LogDir, name), options.Rotation)
 	if err != nil {
 		log.Errorf("Unable to open file for logging: %s", err)
+	} else {
+		backends = append(backends, NewLogBackend(logFile, options.Format, true, options.Redact))
+	}
+
+	return setLogBackends(backends, options)
+}
+
+// openLogFile opens the log file at path for appending, rotating it if a policy is given
+func openLogFile(path string, policy *RotationPolicy) (io.Writer, error) {
+	if policy == nil {
+		return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	}
+
+	return NewRotatingFile(path, afero.NewOsFs(), policy)
+}
+
+// InitCommandLogger logs warnings and errors to stderr for commands that print their own output
+func InitCommandLogger() {
+	options := &LogOptions{Format: logFormatText, Level: "warning", Redact: true}
+
+	setLogBackends([]logging.Backend{NewLogBackend(os.Stderr, logFormatText, false, true)}, options)
+}
+
+// setLogBackends replaces the logging backends and applies the levels of the options.
+// An error is returned if a level or module is invalid.
+func setLogBackends(backends []logging.Backend, options *LogOptions) error {
+	level, err := logging.LogLevel(options.Level)
+	if err != nil {
+		return fmt.Errorf("Invalid log level %q", options.Level)
+	}
+
+	levels := make(map[string]logging.Level)
+	for module, value := range options.Levels {
+		if !isLogModule(module) {
+			return fmt.Errorf("Unknown log module %q, expected one of %s", module, strings.Join(logModules, ", "))
+		}
+
+		levels[module], err = logging.LogLevel(value)
+		if err != nil {
+			return fmt.Errorf("Invalid log level %q for %s", value, module)
+		}
+	}
+
+	leveled := logging.SetBackend(backends...)
+	leveled.SetLevel(level, "")
+
+	for module, level := range levels {
+		leveled.SetLevel(level, module)
+	}
+
+	return nil
+}
+
+func isLogModule(name string) bool {
+	for _, module := range logModules {
+		if module == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LogBackend writes log records as text or JSON lines
+type LogBackend struct {
+	Writer    io.Writer
+	Format    string
+	Timestamp bool
+	Redact    bool
+
+	mu sync.Mutex
+}
+
+// NewLogBackend returns a new LogBackend. Text lines start with the time when timestamp is
+// set, JSON lines always include it.
+func NewLogBackend(w io.Writer, format string, timestamp, redact bool) *LogBackend {
+	backend := &LogBackend{
+		Writer:    w,
+		Format:    format,
+		Timestamp: timestamp,
+		Redact:    redact,
+	}
+
+	return backend
+}
+
+// Log writes a single record
+func (b *LogBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	message, fields := splitFields(rec)
+
+	if b.Redact {
+		message = redact(message)
+		for key, value := range fields {
+			fields[key] = redact(value)
+		}
+	}
+
+	var line []byte
+	if b.Format == logFormatJSON {
+		line = formatJSONLine(rec, message, fields)
+	} else {
+		line = b.formatTextLine(rec, message, fields)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, err := b.Writer.Write(line)
+
+	return err
+}
+
+func (b *LogBackend) formatTextLine(rec *logging.Record, message string, fields Fields) []byte {
+	var buf bytes.Buffer
+
+	if b.Timestamp {
+		buf.WriteString(rec.Time.Format("15:04:05.000 "))
+	}
+
+	fmt.Fprintf(&buf, "[%s] %s%s\n", rec.Level, message, fields)
+
+	return buf.Bytes()
+}
+
+func formatJSONLine(rec *logging.Record, message string, fields Fields) []byte {
+	var buf bytes.Buffer
+
+	writeJSONField := func(key, value string) {
+		if buf.Len() > 0 {
+			buf.WriteByte(',')
+		}
+
+		encodedKey, _ := json.Marshal(key)
+		encodedValue, _ := json.Marshal(value)
+		buf.Write(encodedKey)
+		buf.WriteByte(':')
+		buf.Write(encodedValue)
+	}
+
+	writeJSONField("time", rec.Time.UTC().Format(time.RFC3339Nano))
+	writeJSONField("level", strings.ToLower(rec.Level.String()))
+	writeJSONField("module", rec.Module)
+	writeJSONField("message", message)
+
+	for _, key := range fields.keys() {
+		writeJSONField(key, fields[key])
 	}
 
-	logFileBackend := logging.NewLogBackend(logFile, "", 0)
-	logFileBackendFormat := logging.MustStringFormatter(`%{time:15:04:00.000} [%{level}] %{message}`)
-	logFileBackendFormatter := logging.NewBackendFormatter(logFileBackend, logFileBackendFormat)
+	return []byte("{" + buf.String() + "}\n")
+}
+
+// redact replaces secrets in a log message
+func redact(message string) string {
+	for _, r := range redactions {
+		message = r.pattern.ReplaceAllString(message, r.replacement)
+	}
+
+	return message
+}
+
+// Fields are structured values logged with a message, such as the hostname of a tunnel
+type Fields map[string]string
+
+// String formats the fields as " key=value" pairs sorted by key, skipping empty values
+func (f Fields) String() string {
+	var buf bytes.Buffer
+
+	for _, key := range f.keys() {
+		value := f[key]
+		if strings.ContainsAny(value, " \"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+
+		fmt.Fprintf(&buf, " %s=%s", key, value)
+	}
+
+	return buf.String()
+}
+
+// keys returns the sorted keys of the fields that have a value
+func (f Fields) keys() []string {
+	var keys []string
+
+	for key, value := range f {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// splitFields returns the message of a record without the fields appended by a FieldLogger,
+// along with a copy of those fields
+func splitFields(rec *logging.Record) (string, Fields) {
+	message := rec.Message()
+	fields := make(Fields)
+
+	if len(rec.Args) == 0 {
+		return message, fields
+	}
+
+	last, ok := rec.Args[len(rec.Args)-1].(Fields)
+	if !ok {
+		return message, fields
+	}
+
+	for key, value := range last {
+		fields[key] = value
+	}
+
+	return strings.TrimSuffix(message, last.String()), fields
+}
+
+// FieldLogger logs messages to a module logger with a set of fields
+type FieldLogger struct {
+	Logger *logging.Logger
+	Fields Fields
+}
+
+// withFields returns a FieldLogger for the module logger with the given fields
+func withFields(logger *logging.Logger, fields Fields) *FieldLogger {
+	return &FieldLogger{Logger: logger, Fields: fields}
+}
+
+// With returns a FieldLogger with an additional field
+func (l *FieldLogger) With(key, value string) *FieldLogger {
+	fields := Fields{key: value}
+	for k, v := range l.Fields {
+		if k != key {
+			fields[k] = v
+		}
+	}
+
+	return withFields(l.Logger, fields)
+}
+
+func (l *FieldLogger) Debugf(format string, args ...interface{}) {
+	l.Logger.Debugf(format+"%s", append(args, l.Fields)...)
+}
+
+func (l *FieldLogger) Infof(format string, args ...interface{}) {
+	l.Logger.Infof(format+"%s", append(args, l.Fields)...)
+}
+
+func (l *FieldLogger) Warningf(format string, args ...interface{}) {
+	l.Logger.Warningf(format+"%s", append(args, l.Fields)...)
+}
 
-	logging.SetBackend(strderrBackendFormatter, logFileBackendFormatter)
+func (l *FieldLogger) Errorf(format string, args ...interface{}) {
+	l.Logger.Errorf(format+"%s", append(args, l.Fields)...)
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	logging "github.com/op/go-logging"
+)
+
+func useLogBuffer(t *testing.T, format string, options *LogOptions) *bytes.Buffer {
+	var buf bytes.Buffer
+
+	err := setLogBackends([]logging.Backend{NewLogBackend(&buf, format, false, options.Redact)}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
+
+func resetLogBackends() {
+	setLogBackends([]logging.Backend{NewLogBackend(os.Stderr, logFormatText, false, true)}, DefaultConfig().LogOptions())
+}
+
+func TestTextLogFields(t *testing.T) {
+	defer resetLogBackends()
+	buf := useLogBuffer(t, logFormatText, DefaultConfig().LogOptions())
+
+	withFields(tunnelLog, Fields{fieldHostname: "site.tld", fieldContainerID: ""}).Infof("Starting tunnel %s", "site.tld")
+
+	expected := "[INFO] Starting tunnel site.tld hostname=site.tld\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected log line, got %q want %q", buf.String(), expected)
+	}
+}
+
+func TestJSONLogFields(t *testing.T) {
+	defer resetLogBackends()
+	buf := useLogBuffer(t, logFormatJSON, DefaultConfig().LogOptions())
+
+	logger := withFields(dockerLog, Fields{fieldEvent: "start", fieldContainerID: "5aa5a300dd0e"})
+	logger.With(fieldTunnelState, stateRunning).Errorf("Unable to start")
+
+	var line map[string]string
+	err := json.Unmarshal(buf.Bytes(), &line)
+	if err != nil {
+		t.Fatalf("Unexpected log line %q: %s", buf.String(), err)
+	}
+
+	expected := map[string]string{
+		"level":        "error",
+		"module":       "docker",
+		"message":      "Unable to start",
+		"event":        "start",
+		"container_id": "5aa5a300dd0e",
+		"tunnel_state": "running",
+	}
+
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("Unexpected %s, got %q want %q", key, line[key], value)
+		}
+	}
+
+	if line["time"] == "" {
+		t.Error("Expected time field")
+	}
+}
+
+func TestModuleLogLevels(t *testing.T) {
+	defer resetLogBackends()
+
+	options := DefaultConfig().LogOptions()
+	options.Levels = map[string]string{"tunnel": "debug", "docker": "error"}
+	buf := useLogBuffer(t, logFormatText, options)
+
+	tunnelLog.Debug("tunnel debug")
+	dockerLog.Info("docker info")
+	log.Debug("hera debug")
+	log.Info("hera info")
+
+	if buf.String() != "[DEBUG] tunnel debug\n[INFO] hera info\n" {
+		t.Errorf("Unexpected log output, got %q", buf.String())
+	}
+}
+
+func TestInvalidLogOptions(t *testing.T) {
+	defer resetLogBackends()
+
+	for _, options := range []*LogOptions{
+		{Format: logFormatText, Level: "verbose"},
+		{Format: logFormatText, Level: "info", Levels: map[string]string{"unknown": "debug"}},
+		{Format: logFormatText, Level: "info", Levels: map[string]string{"tunnel": "loud"}},
+	} {
+		err := setLogBackends(nil, options)
+		if err == nil {
+			t.Errorf("Expected error for options %+v", options)
+		}
+	}
+
+	err := InitLogger("hera", &LogOptions{Format: "xml", Level: "info"})
+	if err == nil {
+		t.Error("Expected error for invalid format")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := map[string]string{
+		"origincert /certs/mysite.com.pem":                                        "origincert /certs/[REDACTED]",
+		"token=eyJhbGciOi and more":                                               "token=[REDACTED] and more",
+		`{"TunnelSecret": "c2VjcmV0"}`:                                            `{"TunnelSecret": "[REDACTED]"}`,
+		"-----BEGIN ARGO TUNNEL TOKEN-----\nabc\n-----END ARGO TUNNEL TOKEN-----": "[REDACTED]",
+		"Tunnel site.tld is connected":                                            "Tunnel site.tld is connected",
+	}
+
+	for message, expected := range tests {
+		actual := redact(message)
+		if actual != expected {
+			t.Errorf("Unexpected redaction of %q, got %q want %q", message, actual, expected)
+		}
+	}
+}
+
+func TestRedactedLogLine(t *testing.T) {
+	defer resetLogBackends()
+	buf := useLogBuffer(t, logFormatText, DefaultConfig().LogOptions())
+
+	log.Errorf("Unable to read certificate %s", "/certs/mysite.com.pem")
+
+	if strings.Contains(buf.String(), "mysite.com.pem") {
+		t.Errorf("Expected certificate path to be redacted, got %q", buf.String())
+	}
+}
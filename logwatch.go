@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	logWatchInterval = time.Second
+)
+
+// LogWatcher follows a log file and passes each new line to a handler, similar to `tail -F`.
+// Truncated or replaced files are read again from the beginning.
+type LogWatcher struct {
+	Path string
+	Fs   afero.Fs
+
+	offset      int64
+	partial     []byte
+	skipPartial bool
+	backlogEnd  int64
+	replaying   bool
+
+	once    sync.Once
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewLogWatcher returns a new LogWatcher for the file at path. Lines already in the file
+// are skipped so that only lines written after the watcher was created are handled.
+func NewLogWatcher(path string, fs afero.Fs) *LogWatcher {
+	watcher := &LogWatcher{
+		Path: path,
+		Fs:   fs,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	info, err := fs.Stat(path)
+	if err == nil {
+		watcher.offset = info.Size()
+	}
+
+	return watcher
+}
+
+// Rewind moves the watcher back by up to n bytes so that recent lines already in the file are
+// handled. A line cut off by rewinding is skipped.
+func (w *LogWatcher) Rewind(n int64) {
+	if n <= 0 {
+		return
+	}
+
+	w.backlogEnd = w.offset
+	w.offset -= n
+	if w.offset < 0 {
+		w.offset = 0
+	}
+
+	w.skipPartial = w.offset > 0
+}
+
+// Replaying returns true while the handler is passed a line that was already in the file when
+// the watcher was created. It is only meaningful when called from the handler.
+func (w *LogWatcher) Replaying() bool {
+	return w.replaying
+}
+
+// Start follows the file in the background until the watcher is stopped
+func (w *LogWatcher) Start(handle func(line string)) {
+	w.started = true
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(logWatchInterval)
+		defer ticker.Stop()
+
+		for {
+			err := w.poll(handle)
+			if err != nil {
+				tunnelLog.Errorf("Unable to read %s: %s", w.Path, err)
+			}
+
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops following the file and waits for the watcher to finish
+func (w *LogWatcher) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+
+	if w.started {
+		<-w.done
+	}
+}
+
+// poll reads any lines written since the last poll and passes them to handle
+func (w *LogWatcher) poll(handle func(line string)) error {
+	file, err := w.Fs.Open(w.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() < w.offset {
+		w.offset = 0
+		w.partial = nil
+		w.skipPartial = false
+		w.backlogEnd = 0
+	}
+
+	if info.Size() == w.offset {
+		return nil
+	}
+
+	_, err = file.Seek(w.offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, file)
+	w.offset += n
+	if err != nil {
+		return err
+	}
+
+	data := append(w.partial, buf.Bytes()...)
+	position := w.offset - int64(len(data))
+
+	if w.skipPartial {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			w.partial = nil
+			return nil
+		}
+
+		data = data[i+1:]
+		position += int64(i + 1)
+		w.skipPartial = false
+	}
+
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+
+		position += int64(i + 1)
+		w.replaying = position <= w.backlogEnd
+
+		handle(string(bytes.TrimRight(data[:i], "\r")))
+		data = data[i+1:]
+	}
+
+	w.replaying = false
+
+	w.partial = append([]byte{}, data...)
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestLogWatcherPoll(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+	path := "/var/log/hera/site.tld.log"
+	afero.WriteFile(memFs, path, []byte("old line\n"), 0644)
+
+	watcher := NewLogWatcher(path, memFs)
+
+	var lines []string
+	handle := func(line string) {
+		lines = append(lines, line)
+	}
+
+	afero.WriteFile(memFs, path, []byte("old line\nfirst\nsec"), 0644)
+	err := watcher.poll(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	afero.WriteFile(memFs, path, []byte("old line\nfirst\nsecond\n"), 0644)
+	err = watcher.poll(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	afero.WriteFile(memFs, path, []byte("truncated\n"), 0644)
+	err = watcher.poll(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"first", "second", "truncated"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Unexpected lines, want %v got %v", expected, lines)
+	}
+}
+
+func TestLogWatcherMissingFile(t *testing.T) {
+	watcher := NewLogWatcher("/missing.log", afero.NewMemMapFs())
+
+	err := watcher.poll(func(line string) {
+		t.Errorf("Unexpected line %s", line)
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLogWatcherReplaying(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+	path := "/var/log/hera/site.tld.log"
+	afero.WriteFile(memFs, path, []byte("cut off\nold\n"), 0644)
+
+	watcher := NewLogWatcher(path, memFs)
+	watcher.Rewind(6)
+
+	afero.WriteFile(memFs, path, []byte("cut off\nold\nnew\n"), 0644)
+
+	replayed := make(map[string]bool)
+	err := watcher.poll(func(line string) {
+		replayed[line] = watcher.Replaying()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{"old": true, "new": false}
+	if !reflect.DeepEqual(replayed, expected) {
+		t.Errorf("Unexpected replayed lines, want %v got %v", expected, replayed)
+	}
+}
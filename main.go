@@ -1,30 +1,158 @@
 package main
 
 import (
-	"github.com/op/go-logging"
-)
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
 
-var log = logging.MustGetLogger("hera")
+	"github.com/spf13/afero"
+)
 
 func main() {
-	InitLogger("hera")
+	os.Exit(RunCLI(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// runDaemon runs Hera until it receives a termination signal and returns its exit code
+func runDaemon() int {
+	config, err := loadConfig()
+	if err != nil {
+		log.Errorf("Unable to load config: %s", err)
+		return 1
+	}
+
+	err = InitLogger("hera", config.LogOptions())
+	if err != nil {
+		log.Errorf("Unable to configure logging: %s", err)
+		InitLogger("hera", DefaultConfig().LogOptions())
+	}
 
 	listener, err := NewListener()
 	if err != nil {
 		log.Errorf("Unable to start: %s", err)
+		return 1
 	}
 
 	log.Infof("Hera v%s has started", CurrentVersion)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	handleSignals(cancel)
+
+	servers := NewHTTPServers(config)
+	ServeHTTP(servers)
+
+	if config.ControlSocket != "" {
+		control, err := NewControlServer(config.ControlSocket, listener)
+		if err != nil {
+			log.Errorf("Unable to open control socket: %s", err)
+		} else {
+			servers = append(servers, control)
+		}
+	}
+
 	err = VerifyCertificates(listener.Fs)
 	if err != nil {
 		log.Error(err.Error())
 	}
 
-	err = listener.Revive()
+	report, err := listener.Revive(ctx)
 	if err != nil {
 		log.Error(err.Error())
 	}
 
-	listener.Listen()
+	log.Infof("Revived tunnels: %s", report)
+
+	if logRotationPolicy != nil {
+		rotator := NewLogRotator(afero.NewOsFs(), logRotationPolicy)
+		rotator.Start()
+		defer rotator.Stop()
+	}
+
+	listener.Listen(ctx)
+
+	return Shutdown(config, servers)
+}
+
+// loadConfig loads the config and applies the settings that are kept in package variables.
+// An error is returned if the config cannot be loaded, rather than running on the defaults.
+func loadConfig() (*Config, error) {
+	config, err := LoadConfig(afero.NewOsFs())
+	if err != nil {
+		return nil, err
+	}
+
+	applyTunnelSettings(config)
+
+	crashLoopPolicy = nil
+	if config.CrashLoopThreshold > 0 {
+		crashLoopPolicy = &CrashLoopPolicy{
+			Threshold:  config.CrashLoopThreshold,
+			Window:     config.CrashLoopWindow.Duration,
+			Backoff:    config.CrashLoopBackoff.Duration,
+			MaxBackoff: config.CrashLoopMaxBackoff.Duration,
+		}
+	}
+
+	logRotationPolicy = config.LogRotation()
+	tunnelStopDelay = config.StopDelay.Duration
+
+	notifier = nil
+	if len(config.Webhooks) > 0 {
+		notifier, err = NewNotifier(config.Webhooks, config.WebhookRetries, config.WebhookBackoff.Duration)
+		if err != nil {
+			log.Errorf("Unable to configure webhooks: %s", err)
+		}
+	}
+
+	tunnelLogForwarder = nil
+	if config.SyslogAddr != "" && config.SyslogForwardTunnels {
+		writer, err := NewSyslogWriter(config.SyslogAddr, config.SyslogFacility)
+		if err != nil {
+			log.Errorf("Unable to forward tunnel logs: %s", err)
+		} else {
+			tunnelLogForwarder = NewLogForwarder(writer, config.LogRedact)
+		}
+	}
+
+	return config, nil
+}
+
+// applyTunnelSettings applies the settings that shape the config files of tunnels, which
+// are needed to plan changes as well as to run tunnels
+func applyTunnelSettings(config *Config) {
+	tunnelMetricsPorts = nil
+	if config.TunnelMetricsPorts != "" {
+		allocator, err := NewPortAllocator(tunnelMetricsHost, config.TunnelMetricsPorts)
+		if err != nil {
+			log.Errorf("Unable to allocate tunnel metrics ports: %s", err)
+		} else {
+			tunnelMetricsPorts = allocator
+		}
+	}
+
+	maintenance = nil
+	if config.MaintenanceAddr != "" {
+		maintenance = NewMaintenancePages(config.MaintenanceAddr, config.MaintenancePages, afero.NewOsFs())
+	}
+
+	originCAPath = config.OriginCAPath
+	cloudflaredDefaults = config.Cloudflared
+	addressFamily = config.AddressFamily
+}
+
+// handleSignals calls cancel when Hera receives SIGTERM or SIGINT. A second signal exits
+// immediately.
+func handleSignals(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-signals
+		log.Infof("Received %s, shutting down", sig)
+		cancel()
+
+		sig = <-signals
+		log.Errorf("Received %s, exiting immediately", sig)
+		os.Exit(1)
+	}()
 }
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLoadConfigInvalid(t *testing.T) {
+	file, err := ioutil.TempFile("", "hera-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString(`{"control_socket": `)
+	file.Close()
+
+	os.Setenv("HERA_CONFIG", file.Name())
+	defer os.Unsetenv("HERA_CONFIG")
+
+	config, err := loadConfig()
+	if err == nil || config != nil {
+		t.Error("Expected error instead of the default config")
+	}
+}
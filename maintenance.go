@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	defaultMaintenancePage = "default.html"
+	maintenanceRetryAfter  = "60"
+)
+
+// maintenance serves the maintenance pages that tunnels point at while their container is down.
+// Tunnels are stopped instead when it is nil.
+var maintenance *MaintenancePages
+
+var builtinMaintenancePage = template.Must(template.New("maintenance").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Hostname}} is down for maintenance</title>
+</head>
+<body>
+<h1>{{.Hostname}} is down for maintenance</h1>
+<p>Please check back soon.</p>
+</body>
+</html>
+`))
+
+// MaintenancePages serves the page shown for a hostname while its container is down. A page is
+// read from <hostname>.html in the pages directory, falling back to default.html and then to a
+// built-in page. Pages are Go templates given the hostname.
+type MaintenancePages struct {
+	Addr string
+	Dir  string
+	Fs   afero.Fs
+}
+
+// maintenancePage holds the values available to maintenance page templates
+type maintenancePage struct {
+	Hostname string
+}
+
+// NewMaintenancePages returns new MaintenancePages served on addr with pages from dir
+func NewMaintenancePages(addr, dir string, fs afero.Fs) *MaintenancePages {
+	pages := &MaintenancePages{
+		Addr: addr,
+		Dir:  dir,
+		Fs:   fs,
+	}
+
+	return pages
+}
+
+// Origin returns the address tunnels point at to serve the maintenance page
+func (m *MaintenancePages) Origin() string {
+	host, port, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return m.Addr
+	}
+
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// ServeHTTP responds with the maintenance page for the requested hostname
+func (m *MaintenancePages) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hostname := r.Host
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		hostname = host
+	}
+
+	var body bytes.Buffer
+
+	err := m.template(hostname).Execute(&body, &maintenancePage{Hostname: hostname})
+	if err != nil {
+		apiLog.Errorf("Unable to render maintenance page for %s: %s", hostname, err)
+
+		body.Reset()
+		builtinMaintenancePage.Execute(&body, &maintenancePage{Hostname: hostname})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", maintenanceRetryAfter)
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	if r.Method != http.MethodHead {
+		w.Write(body.Bytes())
+	}
+}
+
+// template returns the page template for a hostname
+func (m *MaintenancePages) template(hostname string) *template.Template {
+	names := []string{defaultMaintenancePage}
+	if hostname != "" && !strings.ContainsAny(hostname, `/\`) && !strings.HasPrefix(hostname, ".") {
+		names = append([]string{hostname + ".html"}, names...)
+	}
+
+	for _, name := range names {
+		contents, err := afero.ReadFile(m.Fs, filepath.Join(m.Dir, name))
+		if err != nil {
+			continue
+		}
+
+		tmpl, err := template.New(name).Parse(string(contents))
+		if err != nil {
+			apiLog.Errorf("Unable to parse maintenance page %s: %s", name, err)
+			continue
+		}
+
+		return tmpl
+	}
+
+	return builtinMaintenancePage
+}
+
+// takeDown stops the tunnel of a container that is down, or points it at the maintenance page
+// if maintenance pages are enabled
+func takeDown(ctx context.Context, tunnel *Tunnel) error {
+	if maintenance == nil {
+		return tunnel.Stop(ctx)
+	}
+
+	return tunnel.EnterMaintenance(ctx, maintenance.Origin())
+}
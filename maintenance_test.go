@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestMaintenancePages(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/pages/site.tld.html", []byte("Back soon, {{.Hostname}}"), 0644)
+	afero.WriteFile(fs, "/pages/default.html", []byte("Down: {{.Hostname}}"), 0644)
+	afero.WriteFile(fs, "/pages/broken.tld.html", []byte("{{.Hostname"), 0644)
+
+	pages := NewMaintenancePages(":8090", "/pages", fs)
+
+	tests := map[string]string{
+		"site.tld":        "Back soon, site.tld",
+		"site.tld:443":    "Back soon, site.tld",
+		"other.tld":       "Down: other.tld",
+		"broken.tld":      "Down: broken.tld",
+		"../pages/x.html": "Down: ../pages/x.html",
+	}
+
+	for host, expected := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+
+		pages.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("Unexpected status for %s, got %d", host, rec.Code)
+		}
+
+		if rec.Body.String() != expected {
+			t.Errorf("Unexpected page for %s, got %q", host, rec.Body.String())
+		}
+	}
+}
+
+func TestBuiltinMaintenancePage(t *testing.T) {
+	pages := NewMaintenancePages(":8090", "/pages", afero.NewMemMapFs())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "site.tld"
+	rec := httptest.NewRecorder()
+
+	pages.ServeHTTP(rec, req)
+
+	if !strings.Contains(rec.Body.String(), "<h1>site.tld is down for maintenance</h1>") {
+		t.Errorf("Unexpected page, got %s", rec.Body.String())
+	}
+}
+
+func TestMaintenanceOrigin(t *testing.T) {
+	origins := map[string]string{
+		":8090":          "127.0.0.1:8090",
+		"0.0.0.0:8090":   "127.0.0.1:8090",
+		"10.0.0.2:8090":  "10.0.0.2:8090",
+		"localhost:8090": "localhost:8090",
+	}
+
+	for addr, expected := range origins {
+		origin := NewMaintenancePages(addr, "", nil).Origin()
+		if origin != expected {
+			t.Errorf("Unexpected origin for %s, got %s", addr, origin)
+		}
+	}
+}
+
+func TestMaintenanceConfig(t *testing.T) {
+	tunnel := newTunnel()
+	tunnel.Config.MaintenanceAddr = "127.0.0.1:8090"
+
+	if !strings.Contains(tunnel.renderConfig(), "url: 127.0.0.1:8090\n") {
+		t.Errorf("Expected tunnel to point at the maintenance page, got %s", tunnel.renderConfig())
+	}
+}
+
+func TestEnterMaintenanceWhileReadingStatus(t *testing.T) {
+	fs = afero.NewMemMapFs()
+
+	tunnel := newTunnel()
+	tunnel.Service.Commander = MockCommander{mockRun: func() ([]byte, error) { return []byte("true"), nil }}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 10; i++ {
+			tunnel.Status(context.Background())
+			tunnel.renderConfig()
+		}
+	}()
+
+	err := tunnel.EnterMaintenance(context.Background(), "127.0.0.1:8090")
+	if err != nil {
+		t.Error(err)
+	}
+
+	<-done
+
+	if status := tunnel.Status(context.Background()); status.State != stateMaintenance {
+		t.Errorf("Unexpected state, got %s", status.State)
+	}
+}
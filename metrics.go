@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var metrics = NewMetrics()
+
+// Metrics holds the collectors exposed on the metrics endpoint
+type Metrics struct {
+	TunnelStarts          *CounterVec
+	TunnelStops           *CounterVec
+	EventDuration         *HistogramVec
+	ResolutionRetries     *CounterVec
+	CommandFailures       *CounterVec
+	EventStreamReconnects *CounterVec
+	TunnelRestarts        *CounterVec
+	TunnelCrashLoops      *CounterVec
+}
+
+// NewMetrics returns a new Metrics with empty collectors
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		TunnelStarts:          NewCounterVec("hera_tunnel_starts_total", "Total number of tunnel starts.", "hostname"),
+		TunnelStops:           NewCounterVec("hera_tunnel_stops_total", "Total number of tunnel stops.", "hostname"),
+		EventDuration:         NewHistogramVec("hera_event_duration_seconds", "Time taken to handle a container event.", "event"),
+		ResolutionRetries:     NewCounterVec("hera_hostname_resolution_retries_total", "Total number of retries when resolving a container hostname.", "hostname"),
+		CommandFailures:       NewCounterVec("hera_command_failures_total", "Total number of failed s6 commands.", "command"),
+		EventStreamReconnects: NewCounterVec("hera_docker_event_reconnects_total", "Total number of reconnects to the Docker event stream."),
+		TunnelRestarts:        NewCounterVec("hera_tunnel_restarts_total", "Total number of observed tunnel service restarts.", "hostname"),
+		TunnelCrashLoops:      NewCounterVec("hera_tunnel_crash_loops_total", "Total number of times a tunnel service was stopped for crash looping.", "hostname"),
+	}
+
+	return m
+}
+
+// Write writes every metric in the Prometheus text format
+func (m *Metrics) Write(ctx context.Context, w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+
+	writeTunnelGauges(ctx, &buf)
+	m.TunnelStarts.write(&buf)
+	m.TunnelStops.write(&buf)
+	m.EventDuration.write(&buf)
+	m.ResolutionRetries.write(&buf)
+	m.CommandFailures.write(&buf)
+	m.EventStreamReconnects.write(&buf)
+	m.TunnelRestarts.write(&buf)
+	m.TunnelCrashLoops.write(&buf)
+	writeTunnelMetrics(&buf)
+
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP responds with every metric in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	_, err := m.Write(r.Context(), w)
+	if err != nil {
+		apiLog.Errorf("Unable to write metrics: %s", err)
+	}
+}
+
+// writeTunnelGauges writes gauges describing the current state of registered tunnels
+func writeTunnelGauges(ctx context.Context, w io.Writer) {
+	var active, failed, disconnected int
+	var up, connected, connections []string
+
+	for _, tunnel := range AllTunnels() {
+		status := tunnel.Status(ctx)
+
+		value := 0
+		if status.State == stateRunning {
+			active++
+			value = 1
+		}
+
+		if status.LastError != "" || status.Connection.State == connectionFailed {
+			failed++
+		}
+
+		isConnected := 0
+		if status.Connection.State == connectionConnected {
+			isConnected = 1
+		} else if status.State == stateRunning {
+			disconnected++
+		}
+
+		labels := formatLabels([]string{"hostname"}, []string{status.Hostname})
+		up = append(up, fmt.Sprintf("hera_tunnel_up%s %d", labels, value))
+		connected = append(connected, fmt.Sprintf("hera_tunnel_connected%s %d", labels, isConnected))
+		connections = append(connections, fmt.Sprintf("hera_tunnel_connections%s %d", labels, status.Connection.Connections))
+	}
+
+	writeHeader(w, "hera_tunnels_active", "Number of tunnels with a running service.", "gauge")
+	fmt.Fprintf(w, "hera_tunnels_active %d\n", active)
+
+	writeHeader(w, "hera_tunnels_failed", "Number of tunnels that failed to start or stop or that cloudflared reported as failed.", "gauge")
+	fmt.Fprintf(w, "hera_tunnels_failed %d\n", failed)
+
+	writeHeader(w, "hera_tunnels_disconnected", "Number of tunnels with a running service that are not connected to Cloudflare.", "gauge")
+	fmt.Fprintf(w, "hera_tunnels_disconnected %d\n", disconnected)
+
+	writeLines(w, "hera_tunnel_up", "Whether the service of a tunnel is running.", "gauge", up)
+	writeLines(w, "hera_tunnel_connected", "Whether cloudflared reports a tunnel as connected to Cloudflare.", "gauge", connected)
+	writeLines(w, "hera_tunnel_connections", "Number of connections cloudflared reports for a tunnel.", "gauge", connections)
+}
+
+func writeLines(w io.Writer, name, help, kind string, lines []string) {
+	writeHeader(w, name, help, kind)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// CounterVec is a counter partitioned by a set of labels
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec returns a new CounterVec with the given name, help text and label names
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	counter := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+
+	return counter
+}
+
+// Inc increments the counter for the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds the given value to the counter for the given label values
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, labelSeparator)
+
+	c.mu.Lock()
+	c.values[key] += value
+	c.mu.Unlock()
+}
+
+// Value returns the current value of the counter for the given label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[strings.Join(labelValues, labelSeparator)]
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+		return
+	}
+
+	for _, key := range sortedKeys(c.values) {
+		labels := formatLabels(c.labels, splitLabelKey(key))
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labels, formatValue(c.values[key]))
+	}
+}
+
+// HistogramVec is a histogram partitioned by a set of labels
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu         sync.Mutex
+	histograms map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// defaultBuckets are the upper bounds in seconds used for every histogram
+var defaultBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30}
+
+// NewHistogramVec returns a new HistogramVec with the given name, help text and label names
+func NewHistogramVec(name, help string, labels ...string) *HistogramVec {
+	vec := &HistogramVec{
+		name:       name,
+		help:       help,
+		labels:     labels,
+		buckets:    defaultBuckets,
+		histograms: make(map[string]*histogram),
+	}
+
+	return vec
+}
+
+// Observe records a duration for the given label values
+func (h *HistogramVec) Observe(duration time.Duration, labelValues ...string) {
+	key := strings.Join(labelValues, labelSeparator)
+	seconds := duration.Seconds()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, ok := h.histograms[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.histograms[key] = hist
+	}
+
+	for i, bound := range h.buckets {
+		if seconds <= bound {
+			hist.counts[i]++
+		}
+	}
+
+	hist.count++
+	hist.sum += seconds
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+
+	keys := make([]string, 0, len(h.histograms))
+	for key := range h.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		hist := h.histograms[key]
+		values := splitLabelKey(key)
+		bucketLabels := append(append([]string{}, h.labels...), "le")
+
+		for i, bound := range h.buckets {
+			labels := formatLabels(bucketLabels, append(append([]string{}, values...), formatValue(bound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, hist.counts[i])
+		}
+
+		labels := formatLabels(bucketLabels, append(append([]string{}, values...), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, hist.count)
+
+		labels = formatLabels(h.labels, values)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatValue(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, hist.count)
+	}
+}
+
+// labelSeparator joins label values into a single map key
+const labelSeparator = "\xff"
+
+func splitLabelKey(key string) []string {
+	if key == "" {
+		return nil
+	}
+
+	return strings.Split(key, labelSeparator)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels returns the label set for the given names and values, e.g. {hostname="site.tld"}
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(value))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCounterVec(t *testing.T) {
+	counter := NewCounterVec("test_total", "Test counter.", "hostname")
+	counter.Inc("b.tld")
+	counter.Inc("a.tld")
+	counter.Add(2, "a.tld")
+
+	var buf bytes.Buffer
+	counter.write(&buf)
+
+	expected := strings.Join([]string{
+		"# HELP test_total Test counter.",
+		"# TYPE test_total counter",
+		`test_total{hostname="a.tld"} 3`,
+		`test_total{hostname="b.tld"} 1`,
+		"",
+	}, "\n")
+
+	if buf.String() != expected {
+		t.Errorf("Unexpected output, got:\n%s", buf.String())
+	}
+}
+
+func TestCounterVecWithoutLabels(t *testing.T) {
+	counter := NewCounterVec("test_total", "Test counter.")
+
+	var buf bytes.Buffer
+	counter.write(&buf)
+
+	if !strings.Contains(buf.String(), "\ntest_total 0\n") {
+		t.Errorf("Expected zero value, got:\n%s", buf.String())
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	histogram := NewHistogramVec("test_seconds", "Test histogram.", "event")
+	histogram.Observe(20*time.Millisecond, "start")
+	histogram.Observe(2*time.Second, "start")
+
+	var buf bytes.Buffer
+	histogram.write(&buf)
+	out := buf.String()
+
+	lines := []string{
+		`test_seconds_bucket{event="start",le="0.01"} 0`,
+		`test_seconds_bucket{event="start",le="0.05"} 1`,
+		`test_seconds_bucket{event="start",le="2.5"} 2`,
+		`test_seconds_bucket{event="start",le="+Inf"} 2`,
+		`test_seconds_count{event="start"} 2`,
+	}
+
+	for _, line := range lines {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected line %s, got:\n%s", line, out)
+		}
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	actual := formatLabels([]string{"a", "b"}, []string{`x"y`, `back\slash`})
+	expected := `{a="x\"y",b="back\\slash"}`
+
+	if actual != expected {
+		t.Errorf("Unexpected labels, want %s got %s", expected, actual)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	heraOriginPrefix = "hera.origin."
+
+	originNoTLSVerify   = "no-tls-verify"
+	originServerName    = "server-name"
+	originCAPool        = "ca-pool"
+	originHostHeader    = "http-host-header"
+	defaultOriginCAPath = "/etc/hera/origin-ca"
+)
+
+var (
+	// originCAPath is the directory the CA pools named in hera.origin.ca-pool labels are read from
+	originCAPath = defaultOriginCAPath
+
+	originNamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?(:[0-9]{1,5})?$`)
+)
+
+// OriginOptions are the settings cloudflared uses to connect to an origin, set with the
+// hera.origin.* labels
+type OriginOptions struct {
+	NoTLSVerify bool
+	ServerName  string
+	CAPool      string
+	HostHeader  string
+}
+
+// parseOriginOptions returns the origin options in the labels of a container, or nil if it has
+// none. CA pools are looked up in originCAPath.
+// An error is returned if a label is unknown or invalid, if a TLS option is set for an origin
+// that is not served over HTTPS, or if a CA pool does not exist.
+func parseOriginOptions(labels map[string]string, protocol string) (*OriginOptions, error) {
+	var names []string
+	for name := range labels {
+		if strings.HasPrefix(name, heraOriginPrefix) {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	sort.Strings(names)
+	options := &OriginOptions{}
+
+	for _, name := range names {
+		value := labels[name]
+		option := strings.TrimPrefix(name, heraOriginPrefix)
+
+		switch option {
+		case originNoTLSVerify:
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s label %q", name, value)
+			}
+			options.NoTLSVerify = enabled
+
+		case originServerName:
+			if !originNamePattern.MatchString(value) || strings.Contains(value, ":") {
+				return nil, fmt.Errorf("Invalid %s label %q", name, value)
+			}
+			options.ServerName = value
+
+		case originCAPool:
+			path, err := originCAPoolPath(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s label %q: %s", name, value, err)
+			}
+			options.CAPool = path
+
+		case originHostHeader:
+			if !originNamePattern.MatchString(value) {
+				return nil, fmt.Errorf("Invalid %s label %q", name, value)
+			}
+			options.HostHeader = value
+
+		default:
+			return nil, fmt.Errorf("Unknown label %s", name)
+		}
+
+		if option != originHostHeader && protocol != protocolHTTPS {
+			return nil, fmt.Errorf("The %s label requires %s=%s", name, heraProtocol, protocolHTTPS)
+		}
+
+		if option == originHostHeader && protocol != protocolHTTP && protocol != protocolHTTPS && protocol != protocolUnix {
+			return nil, fmt.Errorf("The %s label requires an HTTP origin", name)
+		}
+	}
+
+	return options, nil
+}
+
+// originCAPoolPath returns the path of a CA pool file in originCAPath.
+// An error is returned if the name is not a plain file name or the file does not exist.
+func originCAPoolPath(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return "", fmt.Errorf("expected a file name in %s", originCAPath)
+	}
+
+	path := filepath.Join(originCAPath, name)
+
+	exists, err := afero.Exists(fs, path)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists {
+		return "", fmt.Errorf("%s does not exist", path)
+	}
+
+	return path, nil
+}
+
+// tlsConfig returns the TLS settings Hera uses to connect to the origin itself, such as when
+// probing it.
+// An error is returned if the CA pool cannot be read.
+func (o *OriginOptions) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+
+	if o == nil {
+		return config, nil
+	}
+
+	config.InsecureSkipVerify = o.NoTLSVerify
+	config.ServerName = o.ServerName
+
+	if o.CAPool != "" {
+		contents, err := afero.ReadFile(fs, o.CAPool)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(contents) {
+			return nil, fmt.Errorf("No certificates found in %s", o.CAPool)
+		}
+	}
+
+	return config, nil
+}
+
+// configEntries returns the settings of the options in a tunnel config file
+func (o *OriginOptions) configEntries() []configEntry {
+	var entries []configEntry
+
+	if o == nil {
+		return entries
+	}
+
+	if o.NoTLSVerify {
+		entries = append(entries, configEntry{"no-tls-verify", "true"})
+	}
+
+	if o.ServerName != "" {
+		entries = append(entries, configEntry{"origin-server-name", o.ServerName})
+	}
+
+	if o.CAPool != "" {
+		entries = append(entries, configEntry{"origin-ca-pool", o.CAPool})
+	}
+
+	if o.HostHeader != "" {
+		entries = append(entries, configEntry{"http-host-header", o.HostHeader})
+	}
+
+	return entries
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestParseOriginOptions(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	afero.WriteFile(fs, defaultOriginCAPath+"/internal.pem", []byte("ca"), 0644)
+
+	labels := map[string]string{
+		"hera.origin.no-tls-verify":    "true",
+		"hera.origin.server-name":      "app.internal",
+		"hera.origin.ca-pool":          "internal.pem",
+		"hera.origin.http-host-header": "app.internal:8443",
+	}
+
+	options, err := parseOriginOptions(labels, protocolHTTPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &OriginOptions{
+		NoTLSVerify: true,
+		ServerName:  "app.internal",
+		CAPool:      defaultOriginCAPath + "/internal.pem",
+		HostHeader:  "app.internal:8443",
+	}
+
+	if *options != *expected {
+		t.Errorf("Unexpected options, got %+v", options)
+	}
+
+	options, err = parseOriginOptions(map[string]string{heraHostname: "site.tld"}, protocolHTTP)
+	if options != nil || err != nil {
+		t.Errorf("Expected no options, got %+v and %v", options, err)
+	}
+}
+
+func TestParseOriginOptionsErrors(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	afero.WriteFile(fs, defaultOriginCAPath+"/internal.pem", []byte("ca"), 0644)
+
+	tests := []struct {
+		labels   map[string]string
+		protocol string
+	}{
+		{map[string]string{"hera.origin.retries": "3"}, protocolHTTPS},
+		{map[string]string{"hera.origin.no-tls-verify": "yes please"}, protocolHTTPS},
+		{map[string]string{"hera.origin.no-tls-verify": "true"}, protocolHTTP},
+		{map[string]string{"hera.origin.server-name": "app internal"}, protocolHTTPS},
+		{map[string]string{"hera.origin.ca-pool": "missing.pem"}, protocolHTTPS},
+		{map[string]string{"hera.origin.ca-pool": "../certs/site.tld.pem"}, protocolHTTPS},
+		{map[string]string{"hera.origin.http-host-header": "app.internal\nX-Injected: 1"}, protocolHTTP},
+		{map[string]string{"hera.origin.http-host-header": "app.internal"}, protocolTCP},
+	}
+
+	for _, test := range tests {
+		_, err := parseOriginOptions(test.labels, test.protocol)
+		if err == nil {
+			t.Errorf("Expected error for labels %v with %s", test.labels, test.protocol)
+		}
+	}
+}
+
+func TestOriginOptionsConfig(t *testing.T) {
+	tunnel := newTunnel()
+	tunnel.Config.Protocol = protocolHTTPS
+	tunnel.Config.Origin = &OriginOptions{NoTLSVerify: true, ServerName: "app.internal"}
+
+	config := tunnel.renderConfig()
+	if !strings.Contains(config, "url: https://172.23.0.4:80\n") || !strings.Contains(config, "no-tls-verify: true\norigin-server-name: app.internal\n") {
+		t.Errorf("Unexpected config, got %s", config)
+	}
+
+	tunnel.Config.MaintenanceAddr = "127.0.0.1:8090"
+	if strings.Contains(tunnel.renderConfig(), "no-tls-verify") {
+		t.Error("Expected origin options to be left out while in maintenance")
+	}
+}
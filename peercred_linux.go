@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// peerUID returns the user ID of the process on the other end of a Unix socket connection
+func peerUID(conn net.Conn) (int, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return -1, fmt.Errorf("Unexpected connection type %T", conn)
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return -1, err
+	}
+	if credErr != nil {
+		return -1, credErr
+	}
+
+	return int(cred.Uid), nil
+}
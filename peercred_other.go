@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"net"
+)
+
+// peerUID is not supported on this platform, so access to the control socket is restricted
+// by its file permissions alone
+func peerUID(conn net.Conn) (int, error) {
+	return -1, errPeerCredentialsUnsupported
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	planCreate    = "create"
+	planUpdate    = "update"
+	planUnchanged = "unchanged"
+	planStop      = "stop"
+	planError     = "error"
+
+	// planMetricsAddr stands in for the metrics address the daemon assigns to a new tunnel
+	planMetricsAddr = "(assigned on start)"
+)
+
+// PlanEntry describes what Hera would do for a labeled container
+type PlanEntry struct {
+	ContainerID string
+	Hostname    string
+	Action      string
+	Files       []*PlanFile
+	Err         error
+}
+
+// PlanFile holds the diff between a service file on disk and the file Hera would write
+type PlanFile struct {
+	Path string
+	Diff []string
+}
+
+// Plan evaluates every running container the way Hera would when starting tunnels for them
+// and returns what would change, including the running tunnels that a reconcile would stop,
+// without writing any files or starting any services
+func Plan(client *Client) ([]*PlanEntry, error) {
+	var entries []*PlanEntry
+
+	handler := NewHandler(client)
+	containers, err := client.ListContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range containers {
+		container, err := client.Inspect(c.ID)
+		if err != nil {
+			entries = append(entries, &PlanEntry{ContainerID: c.ID, Action: planError, Err: err})
+			continue
+		}
+
+		hostname := getLabel(heraHostname, container)
+		if hostname == "" {
+			continue
+		}
+
+		entry := &PlanEntry{
+			ContainerID: container.ID,
+			Hostname:    hostname,
+		}
+		entries = append(entries, entry)
+
+		tunnel, err := handler.tunnelFromContainer(container)
+		if err == nil && tunnel == nil {
+			err = fmt.Errorf("Missing %s label", heraPort)
+		}
+		if err != nil {
+			entry.Action = planError
+			entry.Err = err
+			continue
+		}
+
+		tunnel.Config.MetricsAddr = plannedMetricsAddr(tunnel)
+		entry.Action, entry.Files = planTunnel(tunnel)
+	}
+
+	entries = append(entries, planStops(context.Background(), entries, Command{})...)
+
+	return entries, nil
+}
+
+// plannedMetricsAddr returns the metrics address the daemon would give a tunnel, which is the
+// address in its existing config file if it is within the port range. The address of a new
+// tunnel is only known once the daemon starts it, since the daemon picks a port that is free
+// at that time.
+func plannedMetricsAddr(tunnel *Tunnel) string {
+	if tunnelMetricsPorts == nil {
+		return ""
+	}
+
+	port, ok := tunnelMetricsPorts.parsePreferred(tunnel.currentConfigValue("metrics"))
+	if !ok {
+		return planMetricsAddr
+	}
+
+	return tunnelMetricsPorts.addr(port)
+}
+
+// planStops returns an entry for every running tunnel service without a labeled container in
+// entries, which a reconcile would stop. Tunnels serving the maintenance page are left running.
+func planStops(ctx context.Context, entries []*PlanEntry, commander Commander) []*PlanEntry {
+	owned := make(map[string]bool)
+	for _, entry := range entries {
+		owned[entry.Hostname] = true
+	}
+
+	dirs, err := afero.ReadDir(fs, ServicesPath)
+	if err != nil {
+		return nil
+	}
+
+	var stops []*PlanEntry
+
+	for _, dir := range dirs {
+		hostname := dir.Name()
+		if !dir.IsDir() || owned[hostname] || strings.HasSuffix(hostname, handoverSuffix) {
+			continue
+		}
+
+		tunnel := NewTunnel(&TunnelConfig{Hostname: hostname}, nil)
+		tunnel.Service.Commander = commander
+
+		// only tunnel services have a config file naming their hostname
+		if tunnel.currentConfigValue("hostname") != hostname {
+			continue
+		}
+
+		if maintenance != nil && tunnel.currentConfigValue("url") == maintenance.Origin() {
+			continue
+		}
+
+		running, err := tunnel.Service.IsRunning(ctx)
+		if err != nil || !running {
+			continue
+		}
+
+		stops = append(stops, &PlanEntry{Hostname: hostname, Action: planStop})
+	}
+
+	return stops
+}
+
+// planTunnel returns the action for a tunnel and the diffs of its service files
+func planTunnel(tunnel *Tunnel) (string, []*PlanFile) {
+	action := planUnchanged
+
+	files := []struct {
+		path     string
+		contents string
+	}{
+		{tunnel.Service.ConfigFilePath(), tunnel.renderConfig()},
+		{tunnel.Service.RunFilePath(), tunnel.renderRunFile()},
+	}
+
+	var planFiles []*PlanFile
+	for _, file := range files {
+		current, err := afero.ReadFile(fs, file.path)
+		if err != nil {
+			action = planCreate
+		}
+
+		diff := diffLines(string(current), file.contents)
+		if !hasChanges(diff) {
+			continue
+		}
+
+		if action == planUnchanged {
+			action = planUpdate
+		}
+
+		planFiles = append(planFiles, &PlanFile{Path: file.path, Diff: diff})
+	}
+
+	return action, planFiles
+}
+
+// Print writes the entry and the diffs of its files
+func (e *PlanEntry) Print(w io.Writer) {
+	id := shortID(e.ContainerID)
+
+	if e.Err != nil {
+		fmt.Fprintf(w, "! %s (%s): %s\n", e.Hostname, id, e.Err)
+		return
+	}
+
+	if id == "" {
+		fmt.Fprintf(w, "%s %s: %s\n", planSymbol(e.Action), e.Hostname, e.Action)
+	} else {
+		fmt.Fprintf(w, "%s %s (%s): %s\n", planSymbol(e.Action), e.Hostname, id, e.Action)
+	}
+
+	for _, file := range e.Files {
+		fmt.Fprintf(w, "    --- %s\n", file.Path)
+		fmt.Fprintf(w, "    +++ %s (planned)\n", file.Path)
+
+		for _, line := range file.Diff {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+	}
+}
+
+func planSymbol(action string) string {
+	switch action {
+	case planCreate:
+		return "+"
+	case planUpdate:
+		return "~"
+	case planStop:
+		return "-"
+	}
+
+	return "="
+}
+
+// RunPlan prints the plan for every labeled container and returns the exit code, which is
+// non-zero if Docker cannot be reached or any container has an error
+func RunPlan(w io.Writer) int {
+	client, err := NewClient()
+	if err != nil {
+		fmt.Fprintf(w, "Unable to connect to Docker: %s\n", err)
+		return 1
+	}
+
+	entries, err := Plan(client)
+	if err != nil {
+		fmt.Fprintf(w, "Unable to list containers: %s\n", err)
+		return 1
+	}
+
+	code := 0
+	counts := make(map[string]int)
+
+	for _, entry := range entries {
+		entry.Print(w)
+		counts[entry.Action]++
+
+		if entry.Err != nil {
+			code = 1
+		}
+	}
+
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to stop, %d unchanged, %d errors\n",
+		counts[planCreate], counts[planUpdate], counts[planStop], counts[planUnchanged], counts[planError])
+
+	return code
+}
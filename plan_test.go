@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestPlanTunnel(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	tunnel := newTunnel()
+
+	action, files := planTunnel(tunnel)
+	if action != planCreate || len(files) != 2 {
+		t.Errorf("Expected create with two files, got %s with %d", action, len(files))
+	}
+
+	tunnel.Service.Create()
+	tunnel.writeConfigFile()
+	tunnel.writeRunFile()
+
+	action, files = planTunnel(tunnel)
+	if action != planUnchanged || len(files) != 0 {
+		t.Errorf("Expected unchanged, got %s with %d files", action, len(files))
+	}
+
+	tunnel.Config.IP = "172.23.0.5"
+
+	action, files = planTunnel(tunnel)
+	if action != planUpdate || len(files) != 1 {
+		t.Fatalf("Expected update with one file, got %s with %d", action, len(files))
+	}
+
+	if files[0].Path != tunnel.Service.ConfigFilePath() {
+		t.Errorf("Unexpected file, got %s", files[0].Path)
+	}
+
+	exists, _ := afero.Exists(fs, tunnel.Service.ConfigFilePath())
+	if !exists {
+		t.Error("Expected plan to leave files in place")
+	}
+}
+
+func TestPlanEntryPrint(t *testing.T) {
+	entry := &PlanEntry{
+		ContainerID: "5aa5a300dd0e1234",
+		Hostname:    "site.tld",
+		Action:      planUpdate,
+		Files: []*PlanFile{
+			{Path: "/var/run/s6/services/site.tld/config.yml", Diff: []string{"-url: a", "+url: b"}},
+		},
+	}
+
+	var buf bytes.Buffer
+	entry.Print(&buf)
+
+	if !strings.HasPrefix(buf.String(), "~ site.tld (5aa5a300dd0e): update\n") {
+		t.Errorf("Unexpected output, got:\n%s", buf.String())
+	}
+
+	if !strings.Contains(buf.String(), "    +url: b\n") {
+		t.Errorf("Expected diff in output, got:\n%s", buf.String())
+	}
+}
+
+func TestPlanStops(t *testing.T) {
+	fs = afero.NewMemMapFs()
+
+	maintenance = NewMaintenancePages("127.0.0.1:8090", "", fs)
+	defer func() { maintenance = nil }()
+
+	services := map[string]string{
+		"gone.tld":          "hostname: gone.tld\nurl: 172.23.0.4:80",
+		"owned.tld":         "hostname: owned.tld\nurl: 172.23.0.5:80",
+		"maintenance.tld":   "hostname: maintenance.tld\nurl: 127.0.0.1:8090",
+		"gone.tld@handover": "hostname: gone.tld\nurl: 172.23.0.4:80",
+		"hera":              "",
+	}
+
+	for name, config := range services {
+		service := NewService(name)
+		service.Create()
+		if config != "" {
+			afero.WriteFile(fs, service.ConfigFilePath(), []byte(config), 0644)
+		}
+	}
+
+	entries := []*PlanEntry{{ContainerID: "5aa5a300dd0e1234", Hostname: "owned.tld", Action: planUnchanged}}
+	running := MockCommander{mockRun: func() ([]byte, error) { return []byte("true"), nil }}
+
+	stops := planStops(context.Background(), entries, running)
+	if len(stops) != 1 || stops[0].Hostname != "gone.tld" || stops[0].Action != planStop {
+		t.Errorf("Expected only gone.tld to be stopped, got %v", stops)
+	}
+
+	stopped := MockCommander{mockRun: func() ([]byte, error) { return []byte("false"), nil }}
+	if stops := planStops(context.Background(), entries, stopped); len(stops) != 0 {
+		t.Errorf("Expected stopped services to be left alone, got %v", stops)
+	}
+
+	var buf bytes.Buffer
+	stops[0].Print(&buf)
+
+	if buf.String() != "- gone.tld: stop\n" {
+		t.Errorf("Unexpected output, got %s", buf.String())
+	}
+}
+
+func TestPlannedMetricsAddr(t *testing.T) {
+	fs = afero.NewMemMapFs()
+
+	allocator, err := NewPortAllocator("127.0.0.1", "41000-41999")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tunnelMetricsPorts = allocator
+	defer func() { tunnelMetricsPorts = nil }()
+
+	tunnel := newTunnel()
+	if addr := plannedMetricsAddr(tunnel); addr != planMetricsAddr {
+		t.Errorf("Expected new tunnel to be assigned on start, got %s", addr)
+	}
+
+	tunnel.Service.Create()
+	tunnel.Config.MetricsAddr = "127.0.0.1:41500"
+	tunnel.writeConfigFile()
+
+	if addr := plannedMetricsAddr(newTunnel()); addr != "127.0.0.1:41500" {
+		t.Errorf("Expected existing address, got %s", addr)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	heraProbe          = "hera.probe"
+	heraProbeInterval  = "hera.probe.interval"
+	heraProbeTimeout   = "hera.probe.timeout"
+	heraProbeThreshold = "hera.probe.threshold"
+
+	probeTCP  = "tcp"
+	probeHTTP = "http"
+
+	defaultProbeInterval  = 5 * time.Second
+	defaultProbeTimeout   = 2 * time.Second
+	defaultProbeThreshold = 3
+)
+
+var (
+	probes   = make(map[string]*ProbeMonitor)
+	probesMu sync.Mutex
+)
+
+// Probe checks whether the origin of a tunnel accepts connections, or answers HTTP requests
+// for a path without an error status. HTTP probes of HTTPS origins are sent over TLS.
+type Probe struct {
+	Kind      string
+	Path      string
+	Interval  time.Duration
+	Timeout   time.Duration
+	Threshold int
+	TLS       *tls.Config
+}
+
+// readinessProbe returns the probe used to check that the origin of a container that replaces
+// another one accepts connections before the tunnel is moved to it
+func readinessProbe() *Probe {
+	probe := &Probe{
+		Kind:      probeTCP,
+		Interval:  time.Second,
+		Timeout:   defaultProbeTimeout,
+		Threshold: defaultProbeThreshold,
+	}
+
+	return probe
+}
+
+// parseProbe returns the probe configured by the labels of a container, or nil if the
+// container has no probe.
+// An error is returned if a probe label is invalid.
+func parseProbe(labels map[string]string) (*Probe, error) {
+	value := labels[heraProbe]
+	if value == "" {
+		return nil, nil
+	}
+
+	bastion, _ := strconv.ParseBool(labels[heraBastion])
+	if labels[heraProtocol] == protocolUnix || bastion {
+		return nil, fmt.Errorf("The %s label requires an origin with an IP address and port", heraProbe)
+	}
+
+	probe := &Probe{
+		Kind:      value,
+		Interval:  defaultProbeInterval,
+		Timeout:   defaultProbeTimeout,
+		Threshold: defaultProbeThreshold,
+	}
+
+	if strings.HasPrefix(value, probeHTTP+":") {
+		probe.Kind = probeHTTP
+		probe.Path = strings.TrimPrefix(value, probeHTTP+":")
+		if !strings.HasPrefix(probe.Path, "/") {
+			probe.Path = "/" + probe.Path
+		}
+	} else if value != probeTCP {
+		return nil, fmt.Errorf("Invalid %s label %q, expected tcp or http:/path", heraProbe, value)
+	}
+
+	if probe.Kind == probeHTTP && labels[heraProtocol] == protocolHTTPS {
+		options, err := parseOriginOptions(labels, protocolHTTPS)
+		if err != nil {
+			return nil, err
+		}
+
+		probe.TLS, err = options.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := labelDuration(labels, heraProbeInterval, &probe.Interval)
+	if err != nil {
+		return nil, err
+	}
+
+	err = labelDuration(labels, heraProbeTimeout, &probe.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if threshold, ok := labels[heraProbeThreshold]; ok {
+		probe.Threshold, err = strconv.Atoi(threshold)
+		if err != nil || probe.Threshold < 1 {
+			return nil, fmt.Errorf("Invalid %s label %q", heraProbeThreshold, threshold)
+		}
+	}
+
+	return probe, nil
+}
+
+// labelDuration sets target to the positive duration in a label if the label exists
+func labelDuration(labels map[string]string, name string, target *time.Duration) error {
+	value, ok := labels[name]
+	if !ok {
+		return nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return fmt.Errorf("Invalid %s label %q", name, value)
+	}
+
+	*target = duration
+
+	return nil
+}
+
+// Check probes the origin at addr once
+func (p *Probe) Check(addr string) error {
+	if p.Kind == probeTCP {
+		conn, err := net.DialTimeout("tcp", addr, p.Timeout)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	}
+
+	client := &http.Client{
+		Timeout: p.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	scheme := "http"
+	if p.TLS != nil {
+		scheme = "https"
+		client.Transport = &http.Transport{TLSClientConfig: p.TLS, DisableKeepAlives: true}
+	}
+
+	resp, err := client.Get(fmt.Sprintf("%s://%s%s", scheme, urlHost(addr), p.Path))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("Unexpected response %s", resp.Status)
+	}
+
+	return nil
+}
+
+// String describes the probe for logging
+func (p *Probe) String() string {
+	if p.Kind == probeHTTP && p.TLS != nil {
+		return fmt.Sprintf("https %s", p.Path)
+	}
+
+	if p.Kind == probeHTTP {
+		return fmt.Sprintf("http %s", p.Path)
+	}
+
+	return p.Kind
+}
+
+// probeTracker decides when an origin is ready or down from the outcomes of its checks. An
+// origin is ready after a successful check and down after Threshold checks fail in a row.
+type probeTracker struct {
+	threshold int
+	failures  int
+	ready     bool
+}
+
+// observe records the outcome of a check and returns true if the origin has become ready or down
+func (p *probeTracker) observe(err error) bool {
+	if err == nil {
+		p.failures = 0
+		if p.ready {
+			return false
+		}
+
+		p.ready = true
+		return true
+	}
+
+	p.failures++
+	if !p.ready || p.failures < p.threshold {
+		return false
+	}
+
+	p.ready = false
+	return true
+}
+
+// ProbeMonitor probes the origin of a tunnel in the background, calling Ready when the origin
+// becomes ready and Down when it goes down. The callbacks are run while holding
+// tunnelOperations. A monitor with StopWhenReady set stops probing once the origin is ready.
+type ProbeMonitor struct {
+	Hostname      string
+	ContainerID   string
+	Probe         *Probe
+	Addr          func() (string, error)
+	Ready         func(context.Context) error
+	Down          func(context.Context) error
+	StopWhenReady bool
+
+	once   sync.Once
+	stop   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewProbeMonitor returns a new ProbeMonitor for the tunnel with the given hostname. The
+// address to probe is looked up before each check.
+func NewProbeMonitor(hostname string, probe *Probe, addr func() (string, error)) *ProbeMonitor {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	monitor := &ProbeMonitor{
+		Hostname: hostname,
+		Probe:    probe,
+		Addr:     addr,
+		stop:     make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	return monitor
+}
+
+// Start probes the origin until the monitor is stopped. Ready is only called once the origin
+// has been seen down if the origin is assumed to be ready to begin with.
+func (m *ProbeMonitor) Start(ready bool) {
+	go func() {
+		tracker := &probeTracker{threshold: m.Probe.Threshold, ready: ready}
+		logger := withFields(tunnelLog, Fields{fieldHostname: m.Hostname})
+
+		for {
+			err := m.check()
+
+			if tracker.observe(err) {
+				m.transition(tracker.ready, err)
+
+				if tracker.ready && m.StopWhenReady {
+					return
+				}
+			} else if err != nil {
+				logger.Debugf("Probe for %s failed (%d/%d): %s", m.Hostname, tracker.failures, m.Probe.Threshold, err)
+			}
+
+			if !m.wait(m.Probe.Interval) {
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops probing. The outcome of a check in progress is ignored, and service commands
+// run by Ready or Down are cancelled.
+func (m *ProbeMonitor) Stop() {
+	m.once.Do(func() {
+		close(m.stop)
+		m.cancel()
+	})
+}
+
+// check probes the current address of the origin
+func (m *ProbeMonitor) check() error {
+	addr, err := m.Addr()
+	if err != nil {
+		return err
+	}
+
+	return m.Probe.Check(addr)
+}
+
+// transition calls Ready or Down unless the monitor has been stopped in the meantime
+func (m *ProbeMonitor) transition(ready bool, err error) {
+	tunnelOperations.Lock()
+	defer tunnelOperations.Unlock()
+
+	if m.stopped() {
+		return
+	}
+
+	logger := withFields(tunnelLog, Fields{fieldHostname: m.Hostname})
+
+	if ready {
+		logger.Infof("Origin for %s is ready", m.Hostname)
+		err = m.Ready(m.ctx)
+	} else {
+		logger.Warningf("Origin for %s is down: %s", m.Hostname, err)
+		err = m.Down(m.ctx)
+	}
+
+	if err != nil {
+		logger.Errorf("%s", err)
+	}
+}
+
+func (m *ProbeMonitor) stopped() bool {
+	select {
+	case <-m.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait returns false if the monitor is stopped before the duration has passed
+func (m *ProbeMonitor) wait(duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-m.stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// watchProbe registers a probe monitor for its hostname and starts it, stopping any monitor
+// already probing the hostname
+func watchProbe(monitor *ProbeMonitor, ready bool) {
+	probesMu.Lock()
+	previous := probes[monitor.Hostname]
+	probes[monitor.Hostname] = monitor
+	probesMu.Unlock()
+
+	if previous != nil {
+		previous.Stop()
+	}
+
+	monitor.Start(ready)
+}
+
+// stopProbing stops probing the origin of the tunnel with the given hostname if the origin is
+// the container with the given ID, or whichever container it is if the ID is empty
+func stopProbing(hostname, containerID string) {
+	probesMu.Lock()
+	monitor := probes[hostname]
+	if monitor != nil && containerID != "" && monitor.ContainerID != containerID {
+		monitor = nil
+	}
+	if monitor != nil {
+		delete(probes, hostname)
+	}
+	probesMu.Unlock()
+
+	if monitor != nil {
+		monitor.Stop()
+	}
+}
+
+// stopAllProbes stops every probe monitor
+func stopAllProbes() {
+	probesMu.Lock()
+	monitors := probes
+	probes = make(map[string]*ProbeMonitor)
+	probesMu.Unlock()
+
+	for _, monitor := range monitors {
+		monitor.Stop()
+	}
+}
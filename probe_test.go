@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestParseProbe(t *testing.T) {
+	probe, err := parseProbe(map[string]string{heraProbe: "http:healthz", heraProbeInterval: "1s", heraProbeThreshold: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if probe.Kind != probeHTTP || probe.Path != "/healthz" || probe.Interval != time.Second || probe.Timeout != defaultProbeTimeout || probe.Threshold != 2 {
+		t.Errorf("Unexpected probe, got %+v", probe)
+	}
+
+	probe, err = parseProbe(map[string]string{})
+	if probe != nil || err != nil {
+		t.Errorf("Expected no probe, got %+v and %v", probe, err)
+	}
+
+	invalid := []map[string]string{
+		{heraProbe: "udp"},
+		{heraProbe: "tcp", heraProbeTimeout: "soon"},
+		{heraProbe: "tcp", heraProbeThreshold: "0"},
+	}
+
+	for _, labels := range invalid {
+		_, err := parseProbe(labels)
+		if err == nil {
+			t.Errorf("Expected error for labels %v", labels)
+		}
+	}
+}
+
+func TestProbeCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	probes := map[*Probe]bool{
+		{Kind: probeTCP, Timeout: time.Second}:                    true,
+		{Kind: probeHTTP, Path: "/healthz", Timeout: time.Second}: true,
+		{Kind: probeHTTP, Path: "/", Timeout: time.Second}:        false,
+	}
+
+	for probe, healthy := range probes {
+		err := probe.Check(addr)
+		if (err == nil) != healthy {
+			t.Errorf("Unexpected result for %s probe, got %v", probe, err)
+		}
+	}
+}
+
+func TestProbeTracker(t *testing.T) {
+	tracker := &probeTracker{threshold: 2}
+	failed := errors.New("connection refused")
+
+	outcomes := []struct {
+		err     error
+		changed bool
+		ready   bool
+	}{
+		{failed, false, false},
+		{nil, true, true},
+		{failed, false, true},
+		{nil, false, true},
+		{failed, false, true},
+		{failed, true, false},
+		{failed, false, false},
+	}
+
+	for i, outcome := range outcomes {
+		if tracker.observe(outcome.err) != outcome.changed || tracker.ready != outcome.ready {
+			t.Errorf("Unexpected state after check %d, got ready %t", i, tracker.ready)
+		}
+	}
+}
+
+func TestProbeMonitor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	healthy := true
+	transitions := make(chan string, 2)
+
+	probe := &Probe{Kind: probeHTTP, Path: "/", Interval: time.Millisecond, Timeout: time.Second, Threshold: 2}
+	monitor := NewProbeMonitor("site.tld", probe, func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !healthy {
+			return "", errors.New("no such host")
+		}
+
+		return strings.TrimPrefix(server.URL, "http://"), nil
+	})
+	monitor.Ready = func(context.Context) error {
+		transitions <- "ready"
+		return nil
+	}
+	monitor.Down = func(context.Context) error {
+		transitions <- "down"
+		return nil
+	}
+
+	monitor.Start(false)
+	defer monitor.Stop()
+
+	for _, expected := range []string{"ready", "down"} {
+		select {
+		case transition := <-transitions:
+			if transition != expected {
+				t.Errorf("Unexpected transition, want %s got %s", expected, transition)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for origin to be %s", expected)
+		}
+
+		mu.Lock()
+		healthy = false
+		mu.Unlock()
+	}
+}
+
+func TestStopAllProbes(t *testing.T) {
+	monitor := NewProbeMonitor("site.tld", &Probe{Kind: probeTCP, Interval: time.Hour}, func() (string, error) {
+		return "", errors.New("unreachable")
+	})
+
+	probesMu.Lock()
+	probes[monitor.Hostname] = monitor
+	probesMu.Unlock()
+
+	stopAllProbes()
+
+	if !monitor.stopped() {
+		t.Error("Expected monitor to be stopped")
+	}
+
+	probesMu.Lock()
+	defer probesMu.Unlock()
+
+	if len(probes) != 0 {
+		t.Errorf("Expected no probes, got %d", len(probes))
+	}
+}
+
+func TestProbeHTTPS(t *testing.T) {
+	fs = afero.NewMemMapFs()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	addr := strings.TrimPrefix(server.URL, "https://")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	afero.WriteFile(fs, defaultOriginCAPath+"/test.pem", certificate, 0644)
+
+	tests := []struct {
+		labels map[string]string
+		ok     bool
+	}{
+		{map[string]string{heraProtocol: protocolHTTPS, heraProbe: "http:/healthz", "hera.origin.no-tls-verify": "true"}, true},
+		{map[string]string{heraProtocol: protocolHTTPS, heraProbe: "http:/healthz", "hera.origin.ca-pool": "test.pem", "hera.origin.server-name": "example.com"}, true},
+		{map[string]string{heraProtocol: protocolHTTPS, heraProbe: "http:/healthz"}, false},
+		{map[string]string{heraProbe: "http:/healthz"}, false},
+	}
+
+	for _, test := range tests {
+		probe, err := parseProbe(test.labels)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = probe.Check(addr)
+		if (err == nil) != test.ok {
+			t.Errorf("Unexpected outcome for labels %v, got %v", test.labels, err)
+		}
+	}
+}
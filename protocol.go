@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	heraProtocol = "hera.protocol"
+	heraSocket   = "hera.socket"
+	heraBastion  = "hera.bastion"
+
+	protocolHTTP  = "http"
+	protocolHTTPS = "https"
+	protocolTCP   = "tcp"
+	protocolSSH   = "ssh"
+	protocolRDP   = "rdp"
+	protocolUnix  = "unix"
+)
+
+// protocols are the origin protocols that can be set with the hera.protocol label
+var protocols = []string{protocolHTTP, protocolHTTPS, protocolTCP, protocolSSH, protocolRDP, protocolUnix}
+
+// parseProtocol sets the origin protocol of config from the labels of a container. Unix socket
+// origins are read from the socket path in the hera.socket label, as seen by Hera on a volume
+// shared with the container, and SSH origins with the hera.bastion label let clients choose
+// the host to connect to.
+// An error is returned if a protocol label is invalid.
+func parseProtocol(labels map[string]string, config *TunnelConfig) error {
+	protocol := labels[heraProtocol]
+	if protocol == "" {
+		protocol = protocolHTTP
+	}
+
+	if !isProtocol(protocol) {
+		return fmt.Errorf("Invalid %s label %q", heraProtocol, protocol)
+	}
+
+	config.Protocol = protocol
+
+	if value, ok := labels[heraBastion]; ok {
+		bastion, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("Invalid %s label %q", heraBastion, value)
+		}
+
+		if bastion && protocol != protocolSSH {
+			return fmt.Errorf("The %s label requires %s=%s", heraBastion, heraProtocol, protocolSSH)
+		}
+
+		config.Bastion = bastion
+	}
+
+	socket := labels[heraSocket]
+
+	if protocol != protocolUnix {
+		if socket != "" {
+			return fmt.Errorf("The %s label requires %s=%s", heraSocket, heraProtocol, protocolUnix)
+		}
+
+		return nil
+	}
+
+	if socket == "" {
+		return fmt.Errorf("Missing %s label", heraSocket)
+	}
+
+	if !filepath.IsAbs(socket) || strings.IndexFunc(socket, isControl) >= 0 {
+		return fmt.Errorf("Invalid %s label %q, expected an absolute path", heraSocket, socket)
+	}
+
+	config.Socket = filepath.Clean(socket)
+
+	return nil
+}
+
+func isProtocol(name string) bool {
+	for _, protocol := range protocols {
+		if protocol == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasNetworkOrigin returns true if the origin is reached at the IP address and port of the
+// container, which is the case unless it is a Unix socket or a bastion
+func (c *TunnelConfig) hasNetworkOrigin() bool {
+	return c.Protocol != protocolUnix && !c.Bastion
+}
+
+// originURL returns the URL cloudflared proxies requests to, or an empty string for a bastion.
+// Plain HTTP origins are written without a scheme as cloudflared defaults to HTTP.
+func (c *TunnelConfig) originURL() string {
+	switch {
+	case c.MaintenanceAddr != "":
+		return c.MaintenanceAddr
+	case c.Bastion:
+		return ""
+	case c.Protocol == protocolUnix:
+		return "unix:" + c.Socket
+	case c.Protocol == "" || c.Protocol == protocolHTTP:
+		return urlHost(net.JoinHostPort(c.IP, c.Port))
+	}
+
+	return fmt.Sprintf("%s://%s", c.Protocol, urlHost(net.JoinHostPort(c.IP, c.Port)))
+}
+
+// sameOrigin returns true if two tunnel configs connect to the same origin in the same way
+func sameOrigin(a, b *TunnelConfig) bool {
+	return a.originURL() == b.originURL() && a.Bastion == b.Bastion && reflect.DeepEqual(a.Origin, b.Origin) && reflect.DeepEqual(a.Settings, b.Settings)
+}
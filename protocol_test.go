@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOriginURL(t *testing.T) {
+	tests := map[string]string{
+		"":      "172.23.0.4:8080",
+		"http":  "172.23.0.4:8080",
+		"https": "https://172.23.0.4:8080",
+		"tcp":   "tcp://172.23.0.4:8080",
+		"ssh":   "ssh://172.23.0.4:8080",
+		"rdp":   "rdp://172.23.0.4:8080",
+	}
+
+	for protocol, expected := range tests {
+		config := &TunnelConfig{IP: "172.23.0.4", Port: "8080", Protocol: protocol}
+		if url := config.originURL(); url != expected {
+			t.Errorf("Unexpected url for %q, want %s got %s", protocol, expected, url)
+		}
+	}
+
+	config := &TunnelConfig{Protocol: protocolUnix, Socket: "/sockets/app.sock"}
+	if url := config.originURL(); url != "unix:/sockets/app.sock" {
+		t.Errorf("Unexpected url for unix socket, got %s", url)
+	}
+}
+
+func TestOriginURLIPv6(t *testing.T) {
+	tests := []struct {
+		config   *TunnelConfig
+		expected string
+	}{
+		{&TunnelConfig{IP: "fd00::4", Port: "8080"}, "[fd00::4]:8080"},
+		{&TunnelConfig{IP: "fd00::4", Port: "8443", Protocol: protocolHTTPS}, "https://[fd00::4]:8443"},
+		{&TunnelConfig{IP: "fe80::42:acff:fe17:4%eth0", Port: "8080"}, "[fe80::42:acff:fe17:4%25eth0]:8080"},
+		{&TunnelConfig{IP: "fe80::42:acff:fe17:4%eth0", Port: "22", Protocol: protocolSSH}, "ssh://[fe80::42:acff:fe17:4%25eth0]:22"},
+	}
+
+	for _, test := range tests {
+		if url := test.config.originURL(); url != test.expected {
+			t.Errorf("Unexpected url for %s, want %s got %s", test.config.IP, test.expected, url)
+		}
+	}
+}
+
+func TestParseProtocol(t *testing.T) {
+	config := &TunnelConfig{}
+	err := parseProtocol(map[string]string{heraProtocol: "unix", heraSocket: "/sockets/../sockets/app.sock"}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Protocol != protocolUnix || config.Socket != "/sockets/app.sock" || config.hasNetworkOrigin() {
+		t.Errorf("Unexpected config, got %+v", config)
+	}
+
+	invalid := []map[string]string{
+		{heraProtocol: "ftp"},
+		{heraProtocol: "unix"},
+		{heraProtocol: "unix", heraSocket: "app.sock"},
+		{heraProtocol: "unix", heraSocket: "/sockets/app.sock\norigincert: /tmp/cert.pem"},
+		{heraProtocol: "http", heraSocket: "/sockets/app.sock"},
+		{heraProtocol: "tcp", heraBastion: "true"},
+		{heraProtocol: "ssh", heraBastion: "maybe"},
+	}
+
+	for _, labels := range invalid {
+		err := parseProtocol(labels, &TunnelConfig{})
+		if err == nil {
+			t.Errorf("Expected error for labels %v", labels)
+		}
+	}
+}
+
+func TestConfigValuesAreQuoted(t *testing.T) {
+	tunnel := newTunnel()
+	tunnel.Config.IP = "fd00::4"
+
+	config := tunnel.renderConfig()
+	if !strings.Contains(config, "\nurl: \"[fd00::4]:80\"\n") {
+		t.Errorf("Expected quoted IPv6 url, got %s", config)
+	}
+
+	tunnel.Config.Protocol = protocolUnix
+	tunnel.Config.Socket = "/sockets/app.sock\norigincert: /tmp/cert.pem"
+
+	config = tunnel.renderConfig()
+	if strings.Contains(config, "\norigincert: /tmp/cert.pem") {
+		t.Errorf("Expected url to stay on one line, got %s", config)
+	}
+}
+
+func TestBastionConfig(t *testing.T) {
+	tunnel := newTunnel()
+	tunnel.Config.Protocol = protocolSSH
+	tunnel.Config.Bastion = true
+
+	config := tunnel.renderConfig()
+
+	if strings.Contains(config, "url:") || !strings.Contains(config, "bastion: true") {
+		t.Errorf("Unexpected bastion config, got %s", config)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	logRotationInterval = time.Minute
+)
+
+// RotationPolicy decides when a log file is rotated and how many rotated files are kept
+type RotationPolicy struct {
+	MaxSize  int64
+	MaxAge   time.Duration
+	MaxFiles int
+	Compress bool
+}
+
+// logRotationPolicy is the policy applied to the tunnel logs. Rotation is disabled when it
+// is nil.
+var logRotationPolicy *RotationPolicy
+
+// due returns true if a file of the given size that was started at the given time should be
+// rotated
+func (p *RotationPolicy) due(size int64, started, now time.Time) bool {
+	if size == 0 {
+		return false
+	}
+
+	if p.MaxSize > 0 && size >= p.MaxSize {
+		return true
+	}
+
+	return p.MaxAge > 0 && now.Sub(started) >= p.MaxAge
+}
+
+// rotatedPath returns the path of the nth rotated file for path
+func (p *RotationPolicy) rotatedPath(path string, n int) string {
+	rotated := fmt.Sprintf("%s.%d", path, n)
+	if p.Compress {
+		rotated += ".gz"
+	}
+
+	return rotated
+}
+
+// shift makes room for a newly rotated file by renaming each rotated file of path to the next
+// number, removing the files beyond MaxFiles. It returns the path for the newly rotated file.
+func (p *RotationPolicy) shift(fs afero.Fs, path string) (string, error) {
+	for _, suffix := range []string{"", ".gz"} {
+		err := fs.Remove(fmt.Sprintf("%s.%d%s", path, p.MaxFiles, suffix))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+
+		for n := p.MaxFiles - 1; n >= 1; n-- {
+			err := fs.Rename(fmt.Sprintf("%s.%d%s", path, n, suffix), fmt.Sprintf("%s.%d%s", path, n+1, suffix))
+			if err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+	}
+
+	return p.rotatedPath(path, 1), nil
+}
+
+// rotateByRename moves the file at path to the first rotated file, used for files that are
+// written by Hera itself and reopened afterwards
+func (p *RotationPolicy) rotateByRename(fs afero.Fs, path string) error {
+	if p.MaxFiles < 1 {
+		return fs.Remove(path)
+	}
+
+	rotated, err := p.shift(fs, path)
+	if err != nil {
+		return err
+	}
+
+	if !p.Compress {
+		return fs.Rename(path, rotated)
+	}
+
+	err = copyFile(fs, path, rotated, true)
+	if err != nil {
+		return err
+	}
+
+	return fs.Remove(path)
+}
+
+// rotateByCopy copies the file at path to the first rotated file and truncates it, used for
+// files that are kept open by another process such as cloudflared. Lines written while the file
+// is copied may be lost.
+func (p *RotationPolicy) rotateByCopy(fs afero.Fs, path string) error {
+	if p.MaxFiles > 0 {
+		rotated, err := p.shift(fs, path)
+		if err != nil {
+			return err
+		}
+
+		err = copyFile(fs, path, rotated, p.Compress)
+		if err != nil {
+			return err
+		}
+	}
+
+	file, err := fs.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// copyFile copies the file at src to dst, compressing it with gzip if compress is set
+func copyFile(fs afero.Fs, src, dst string, compress bool) error {
+	in, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := fs.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if !compress {
+		_, err = io.Copy(out, in)
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+
+	_, err = io.Copy(gz, in)
+	if err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// RotatingFile is a log file written by Hera that is rotated according to a policy
+type RotatingFile struct {
+	Path   string
+	Fs     afero.Fs
+	Policy *RotationPolicy
+
+	mu      sync.Mutex
+	file    afero.File
+	size    int64
+	started time.Time
+}
+
+// NewRotatingFile opens the file at path for appending.
+// An error is returned if the file cannot be opened.
+func NewRotatingFile(path string, fs afero.Fs, policy *RotationPolicy) (*RotatingFile, error) {
+	rotating := &RotatingFile{
+		Path:   path,
+		Fs:     fs,
+		Policy: policy,
+	}
+
+	err := rotating.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return rotating, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := f.Fs.OpenFile(f.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.started = time.Now()
+
+	return nil
+}
+
+// Write appends to the file, rotating it first if it is due
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Policy.due(f.size+int64(len(p)), f.started, time.Now()) && f.size > 0 {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+
+	err = f.Policy.rotateByRename(f.Fs, f.Path)
+	if err != nil {
+		f.open()
+		return err
+	}
+
+	return f.open()
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
+
+// LogRotator periodically rotates the log files of every tunnel
+type LogRotator struct {
+	Fs     afero.Fs
+	Policy *RotationPolicy
+
+	started map[string]time.Time
+	once    sync.Once
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewLogRotator returns a new LogRotator
+func NewLogRotator(fs afero.Fs, policy *RotationPolicy) *LogRotator {
+	rotator := &LogRotator{
+		Fs:      fs,
+		Policy:  policy,
+		started: make(map[string]time.Time),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	return rotator
+}
+
+// Start rotates the tunnel logs in the background until the rotator is stopped
+func (r *LogRotator) Start() {
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(logRotationInterval)
+		defer ticker.Stop()
+
+		for {
+			r.rotateAll(time.Now())
+
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops rotating and waits for the rotator to finish
+func (r *LogRotator) Stop() {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+
+	<-r.done
+}
+
+// rotateAll rotates the log of every tunnel that is due. The age of a log is counted from when
+// the rotator first saw it.
+func (r *LogRotator) rotateAll(now time.Time) {
+	for _, tunnel := range AllTunnels() {
+		path := tunnel.Service.LogFilePath()
+
+		info, err := r.Fs.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		started, ok := r.started[path]
+		if !ok {
+			started = now
+			r.started[path] = now
+		}
+
+		if !r.Policy.due(info.Size(), started, now) {
+			continue
+		}
+
+		err = r.Policy.rotateByCopy(r.Fs, path)
+		if err != nil {
+			tunnel.logger().Errorf("Unable to rotate %s: %s", path, err)
+			continue
+		}
+
+		tunnel.logger().Debugf("Rotated %s", path)
+		r.started[path] = now
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func readGzipFile(t *testing.T, fs afero.Fs, path string) string {
+	file, err := fs.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(contents)
+}
+
+func TestRotationDue(t *testing.T) {
+	now := time.Now()
+	policy := &RotationPolicy{MaxSize: 100, MaxAge: time.Hour}
+
+	tests := []struct {
+		size    int64
+		started time.Time
+		due     bool
+	}{
+		{0, now.Add(-2 * time.Hour), false},
+		{50, now, false},
+		{100, now, true},
+		{50, now.Add(-2 * time.Hour), true},
+	}
+
+	for _, test := range tests {
+		if policy.due(test.size, test.started, now) != test.due {
+			t.Errorf("Unexpected due for size %d started %s, want %t", test.size, test.started, test.due)
+		}
+	}
+}
+
+func TestRotateByCopy(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	policy := &RotationPolicy{MaxSize: 1, MaxFiles: 2, Compress: true}
+	path := "/var/log/hera/site.tld.log"
+
+	for _, contents := range []string{"first\n", "second\n", "third\n"} {
+		afero.WriteFile(fs, path, []byte(contents), 0644)
+
+		err := policy.rotateByCopy(fs, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	current, _ := afero.ReadFile(fs, path)
+	if len(current) != 0 {
+		t.Errorf("Expected log to be truncated, got %q", current)
+	}
+
+	if readGzipFile(t, fs, path+".1.gz") != "third\n" || readGzipFile(t, fs, path+".2.gz") != "second\n" {
+		t.Error("Unexpected rotated files")
+	}
+
+	exists, _ := afero.Exists(fs, path+".3.gz")
+	if exists {
+		t.Error("Expected files beyond the retention count to be removed")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	policy := &RotationPolicy{MaxSize: 10, MaxFiles: 1}
+
+	file, err := NewRotatingFile("/hera", fs, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte("12345678\n"))
+	file.Write([]byte("abcdefgh\n"))
+	file.Close()
+
+	current, _ := afero.ReadFile(fs, "/hera")
+	rotated, _ := afero.ReadFile(fs, "/hera.1")
+
+	if string(current) != "abcdefgh\n" || string(rotated) != "12345678\n" {
+		t.Errorf("Unexpected files, got %q and %q", current, rotated)
+	}
+}
+
+func TestLogRotator(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	tunnel := newTunnel()
+	registerTunnel(tunnel)
+	defer unregisterTunnel(tunnel)
+
+	path := tunnel.Service.LogFilePath()
+	afero.WriteFile(fs, path, []byte("connected\n"), 0644)
+
+	rotator := NewLogRotator(fs, &RotationPolicy{MaxAge: time.Hour, MaxFiles: 1})
+	now := time.Now()
+
+	rotator.rotateAll(now)
+
+	exists, _ := afero.Exists(fs, path+".1")
+	if exists {
+		t.Error("Expected new log not to be rotated")
+	}
+
+	rotator.rotateAll(now.Add(time.Hour))
+
+	rotated, _ := afero.ReadFile(fs, path+".1")
+	if string(rotated) != "connected\n" {
+		t.Errorf("Unexpected rotated log, got %q", rotated)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+)
+
+// NewHTTPServers returns the HTTP servers for the endpoints enabled in the config.
+// Endpoints configured with the same address share a server.
+func NewHTTPServers(config *Config) []*http.Server {
+	var servers []*http.Server
+	muxes := make(map[string]*http.ServeMux)
+
+	muxFor := func(addr string) *http.ServeMux {
+		mux, ok := muxes[addr]
+		if !ok {
+			mux = http.NewServeMux()
+			muxes[addr] = mux
+			servers = append(servers, &http.Server{Addr: addr, Handler: mux})
+		}
+
+		return mux
+	}
+
+	if config.StatusAddr != "" {
+		registerStatusRoutes(muxFor(config.StatusAddr))
+	}
+
+	if config.MetricsAddr != "" {
+		muxFor(config.MetricsAddr).Handle("/metrics", metrics)
+	}
+
+	if config.MaintenanceAddr != "" {
+		muxFor(config.MaintenanceAddr).Handle("/", maintenance)
+	}
+
+	return servers
+}
+
+// ServeHTTP serves requests for each server in the background
+func ServeHTTP(servers []*http.Server) {
+	for _, server := range servers {
+		go func(server *http.Server) {
+			apiLog.Infof("Serving HTTP on %s", server.Addr)
+
+			err := server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				apiLog.Errorf("Unable to serve HTTP on %s: %s", server.Addr, err)
+			}
+		}(server)
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
     "path/filepath"
+    "strconv"
     "strings"
+    "time"
 
     "github.com/spf13/afero"
 )
@@ -73,9 +77,29 @@ func (s *Service) Create() error {
 	return nil
 }
 
+// Remove removes the directory and log file of a stopped service and has s6 stop supervising it
+func (s *Service) Remove(ctx context.Context) error {
+	err := fs.RemoveAll(s.servicePath())
+	if err != nil {
+		return err
+	}
+
+	err = fs.Remove(s.LogFilePath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	_, err = s.Commander.Run(ctx, "s6-svscanctl", "-an", ServicesPath)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Supervise supervises a service
-func (s *Service) Supervise() error {
-	_, err := s.Commander.Run("s6-svscanctl", "-a", ServicesPath)
+func (s *Service) Supervise(ctx context.Context) error {
+	_, err := s.Commander.Run(ctx, "s6-svscanctl", "-a", ServicesPath)
 	if err != nil {
 		return err
 	}
@@ -84,8 +108,8 @@ func (s *Service) Supervise() error {
 }
 
 // Start starts a service
-func (s *Service) Start() error {
-	_, err := s.Commander.Run("s6-svc", "-u", s.servicePath())
+func (s *Service) Start(ctx context.Context) error {
+	_, err := s.Commander.Run(ctx, "s6-svc", "-u", s.servicePath())
 	if err != nil {
 		return err
 	}
@@ -94,8 +118,8 @@ func (s *Service) Start() error {
 }
 
 // Stop stops a service
-func (s *Service) Stop() error {
-	_, err := s.Commander.Run("s6-svc", "-d", s.servicePath())
+func (s *Service) Stop(ctx context.Context) error {
+	_, err := s.Commander.Run(ctx, "s6-svc", "-d", s.servicePath())
 	if err != nil {
 		return err
 	}
@@ -103,14 +127,19 @@ func (s *Service) Stop() error {
 	return nil
 }
 
-// Restart restarts a service
-func (s *Service) Restart() error {
-	err := s.waitUntilDown()
+// Restart stops a service, waits until it is down and starts it again
+func (s *Service) Restart(ctx context.Context) error {
+	err := s.Stop(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.waitUntilDown(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = s.Start()
+	err = s.Start(ctx)
 	if err != nil {
 		return err
 	}
@@ -119,8 +148,8 @@ func (s *Service) Restart() error {
 }
 
 // waitUntilDown returns when the service is down
-func (s *Service) waitUntilDown() error {
-	_, err := s.Commander.Run("s6-svwait", "-d", s.servicePath())
+func (s *Service) waitUntilDown(ctx context.Context) error {
+	_, err := s.Commander.Run(ctx, "s6-svwait", "-d", s.servicePath())
 	if err != nil {
 		return err
 	}
@@ -139,11 +168,74 @@ func (s *Service) IsSupervised() (bool, error) {
 }
 
 // IsRunning returns a bool to indicate if a service is running or not
-func (s *Service) IsRunning() (bool, error) {
-	out, err := s.Commander.Run("s6-svstat", "-u", s.servicePath())
+func (s *Service) IsRunning(ctx context.Context) (bool, error) {
+	status, err := s.Status(ctx)
 	if err != nil {
 		return false, err
 	}
 
-	return strings.Contains(string(out), "true"), nil
+	return status.Up, nil
+}
+
+// ServiceStatus holds the state of a service as reported by s6-svstat
+type ServiceStatus struct {
+	Up       bool          `json:"up"`
+	WantedUp bool          `json:"wanted_up"`
+	PID      int           `json:"pid"`
+	ExitCode int           `json:"exit_code"`
+	Signal   string        `json:"signal,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// serviceStatusFields are the s6-svstat fields parsed into a ServiceStatus, in order
+const serviceStatusFields = "up,wantedup,pid,exitcode,signal,updownfor"
+
+// Status returns the current state of a service
+func (s *Service) Status(ctx context.Context) (*ServiceStatus, error) {
+	out, err := s.Commander.Run(ctx, "s6-svstat", "-o", serviceStatusFields, s.servicePath())
+	if err != nil {
+		return nil, err
+	}
+
+	return parseServiceStatus(string(out))
+}
+
+// parseServiceStatus parses the output of s6-svstat for the fields in serviceStatusFields.
+// Missing fields keep their zero value, with the pid and exit code defaulting to -1.
+func parseServiceStatus(out string) (*ServiceStatus, error) {
+	status := &ServiceStatus{
+		PID:      -1,
+		ExitCode: -1,
+	}
+
+	fields := strings.Fields(out)
+
+	for i, field := range fields {
+		var err error
+
+		switch i {
+		case 0:
+			status.Up = field == "true"
+		case 1:
+			status.WantedUp = field == "true"
+		case 2:
+			status.PID, err = strconv.Atoi(field)
+		case 3:
+			status.ExitCode, err = strconv.Atoi(field)
+		case 4:
+			if field != "NA" {
+				status.Signal = field
+			}
+		case 5:
+			var seconds int
+			seconds, err = strconv.Atoi(field)
+			status.Duration = time.Duration(seconds) * time.Second
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse service status %q: %s", out, err)
+		}
+	}
+
+	return status, nil
 }
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 )
@@ -13,7 +16,7 @@ type MockCommander struct {
 	mockRun func() ([]byte, error)
 }
 
-func (c MockCommander) Run(name string, arg ...string) ([]byte, error) {
+func (c MockCommander) Run(ctx context.Context, name string, arg ...string) ([]byte, error) {
 	return c.mockRun()
 }
 
@@ -101,7 +104,7 @@ func TestIsRunning(t *testing.T) {
 		},
 	}
 
-	running, err := service.IsRunning()
+	running, err := service.IsRunning(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -116,7 +119,7 @@ func TestIsRunning(t *testing.T) {
 		},
 	}
 
-	running, err = service.IsRunning()
+	running, err = service.IsRunning(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -125,3 +128,74 @@ func TestIsRunning(t *testing.T) {
 		t.Error("Service should not be running")
 	}
 }
+
+func TestParseServiceStatus(t *testing.T) {
+	status, err := parseServiceStatus("true true 123 -1 NA 45\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !status.Up || !status.WantedUp || status.PID != 123 || status.ExitCode != -1 || status.Signal != "" || status.Duration != 45*time.Second {
+		t.Errorf("Unexpected status, got %+v", status)
+	}
+
+	status, err = parseServiceStatus("false true -1 1 NA 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Up || status.ExitCode != 1 {
+		t.Errorf("Unexpected status, got %+v", status)
+	}
+
+	_, err = parseServiceStatus("true true abc")
+	if err == nil {
+		t.Error("Expected error for invalid pid")
+	}
+}
+
+func TestRestart(t *testing.T) {
+	var commands []string
+	restartService := NewService("site.tld")
+	restartService.Commander = &RecordingCommander{commands: &commands}
+
+	err := restartService.Restart(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected := []string{"s6-svc -d", "s6-svwait -d", "s6-svc -u"}
+	for i, command := range expected {
+		if i >= len(commands) || !strings.HasPrefix(commands[i], command) {
+			t.Errorf("Unexpected commands, want %v got %v", expected, commands)
+			break
+		}
+	}
+}
+
+func TestRestartCancelled(t *testing.T) {
+	var commands []string
+	restartService := NewService("site.tld")
+	restartService.Commander = &RecordingCommander{commands: &commands}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := restartService.Restart(ctx)
+	if err != context.Canceled {
+		t.Errorf("Expected cancelled restart, got %v", err)
+	}
+
+	if len(commands) != 1 {
+		t.Errorf("Expected restart to stop after the first command, got %v", commands)
+	}
+}
+
+type RecordingCommander struct {
+	commands *[]string
+}
+
+func (c RecordingCommander) Run(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	*c.commands = append(*c.commands, strings.Join(append([]string{name}, arg...), " "))
+	return nil, ctx.Err()
+}
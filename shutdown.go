@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	shutdownTimeout = 30 * time.Second
+)
+
+// State is a snapshot of Hera's tunnels written to disk when Hera stops
+type State struct {
+	Version   string          `json:"version"`
+	StoppedAt time.Time       `json:"stopped_at"`
+	Tunnels   []*TunnelStatus `json:"tunnels"`
+}
+
+// Shutdown stops the HTTP servers, probes and the background tasks of every tunnel, stopping
+// the tunnels themselves if configured to, and writes the state of the tunnels to disk.
+// It returns the exit code for Hera, which is non-zero if any step failed.
+func Shutdown(config *Config, servers []*http.Server) int {
+	code := 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, server := range servers {
+		err := server.Shutdown(ctx)
+		if err != nil {
+			log.Errorf("Unable to stop HTTP server on %s: %s", server.Addr, err)
+			code = 1
+		}
+	}
+
+	// Probes, pending stops and other background tasks are kept from starting or stopping
+	// tunnels until Hera has stopped
+	stopAllProbes()
+
+	tunnelOperations.Lock()
+	defer tunnelOperations.Unlock()
+
+	cancelAllStops()
+
+	for _, tunnel := range AllTunnels() {
+		if !config.StopTunnelsOnExit {
+			tunnel.release()
+			continue
+		}
+
+		err := tunnel.Stop(ctx)
+		if err != nil {
+			log.Errorf("Unable to stop tunnel %s: %s", tunnel.Config.Hostname, err)
+			code = 1
+		}
+	}
+
+	if config.StopTunnelsOnExit {
+		log.Info("Stopped all tunnels")
+	} else {
+		log.Info("Leaving tunnels running")
+	}
+
+	if notifier != nil && !notifier.Wait(shutdownTimeout) {
+		log.Error("Gave up waiting for webhooks to be sent")
+		code = 1
+	}
+
+	if config.StatePath != "" {
+		err := WriteState(ctx, fs, config.StatePath)
+		if err != nil {
+			log.Errorf("Unable to write state to %s: %s", config.StatePath, err)
+			code = 1
+		}
+	}
+
+	log.Infof("Hera has stopped")
+
+	return code
+}
+
+// WriteState writes the status of every tunnel to the file at path
+func WriteState(ctx context.Context, fs afero.Fs, path string) error {
+	state := &State{
+		Version:   CurrentVersion,
+		StoppedAt: time.Now(),
+		Tunnels:   []*TunnelStatus{},
+	}
+
+	for _, tunnel := range AllTunnels() {
+		state.Tunnels = append(state.Tunnels, tunnel.Status(ctx))
+	}
+
+	contents, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = fs.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return afero.WriteFile(fs, path, contents, 0644)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestWriteState(t *testing.T) {
+	tunnel := newTunnel()
+	tunnel.Service.Commander = &MockCommander{
+		mockRun: func() ([]byte, error) {
+			return []byte("true"), nil
+		},
+	}
+	registerTunnel(tunnel)
+
+	memFs := afero.NewMemMapFs()
+	path := "/var/lib/hera/state.json"
+
+	err := WriteState(context.Background(), memFs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := afero.ReadFile(memFs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var state State
+	err = json.Unmarshal(contents, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state.Version != CurrentVersion || len(state.Tunnels) == 0 {
+		t.Errorf("Unexpected state, got %+v", state)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const (
+	stateRunning     = "running"
+	stateStopped     = "stopped"
+	stateFailed      = "failed"
+	stateMaintenance = "maintenance"
+	stateUnknown     = "unknown"
+)
+
+// TunnelStatus describes the current state of a tunnel
+type TunnelStatus struct {
+	Hostname    string           `json:"hostname"`
+	ContainerID string           `json:"container_id"`
+	IP          string           `json:"ip"`
+	Port        string           `json:"port"`
+	Origin      string           `json:"origin"`
+	Certificate string           `json:"certificate"`
+	State       string           `json:"state"`
+	Service     *ServiceStatus   `json:"service,omitempty"`
+	Connection  ConnectionStatus `json:"connection"`
+	CrashLoop   CrashLoopStatus  `json:"crash_loop"`
+	LastError   string           `json:"last_error,omitempty"`
+}
+
+// Status returns the current status of the tunnel, querying its service for the running state
+func (t *Tunnel) Status(ctx context.Context) *TunnelStatus {
+	config := t.config()
+
+	status := &TunnelStatus{
+		Hostname:    config.Hostname,
+		ContainerID: config.ContainerID,
+		IP:          config.IP,
+		Port:        config.Port,
+		Origin:      statusOrigin(&config),
+		Certificate: t.Certificate.FullPath(),
+		State:       stateUnknown,
+		Connection:  t.Connection(),
+		CrashLoop:   t.CrashLoop(),
+	}
+
+	service, err := t.Service.Status(ctx)
+	if err == nil {
+		status.Service = service
+		status.State = stateStopped
+		if service.Up {
+			status.State = stateRunning
+		}
+		if service.Up && t.InMaintenance() {
+			status.State = stateMaintenance
+		}
+	}
+
+	if status.CrashLoop.Failed {
+		status.State = stateFailed
+	}
+
+	if lastErr := t.LastError(); lastErr != nil {
+		status.LastError = lastErr.Error()
+	}
+
+	return status
+}
+
+// statusOrigin returns the origin shown in the status of a tunnel, which is the URL cloudflared
+// proxies to even while the tunnel serves its maintenance page, or "bastion" for a bastion
+func statusOrigin(config *TunnelConfig) string {
+	if config.Bastion {
+		return "bastion"
+	}
+
+	origin := *config
+	origin.MaintenanceAddr = ""
+
+	return origin.originURL()
+}
+
+// registerStatusRoutes adds the status endpoints to the given mux
+func registerStatusRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/tunnels", handleListTunnels)
+	mux.HandleFunc("/tunnels/", handleGetTunnel)
+}
+
+// handleListTunnels responds with the status of every registered tunnel
+func handleListTunnels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	statuses := []*TunnelStatus{}
+	for _, tunnel := range AllTunnels() {
+		statuses = append(statuses, tunnel.Status(r.Context()))
+	}
+
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// handleGetTunnel responds with the status of the tunnel for the hostname in the request path
+func handleGetTunnel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	hostname := strings.TrimPrefix(r.URL.Path, "/tunnels/")
+
+	tunnel, err := GetTunnelForHost(hostname)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tunnel.Status(r.Context()))
+}
+
+// writeJSON writes value as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		apiLog.Errorf("Unable to write response: %s", err)
+	}
+}
+
+// writeJSONError writes an error message as the JSON response body with the given status code
+func writeJSONError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTunnelStatus(t *testing.T) {
+	tunnel := newTunnel()
+	tunnel.Service.Commander = &MockCommander{
+		mockRun: func() ([]byte, error) {
+			return []byte("true"), nil
+		},
+	}
+
+	status := tunnel.Status(context.Background())
+	if status.State != stateRunning {
+		t.Errorf("Unexpected state, got %s", status.State)
+	}
+
+	if status.Origin != "172.23.0.4:80" {
+		t.Errorf("Unexpected origin, got %s", status.Origin)
+	}
+
+	tunnel.setLastError(errors.New("failed"))
+	tunnel.Service.Commander = &MockCommander{
+		mockRun: func() ([]byte, error) {
+			return nil, errors.New("s6-svstat failed")
+		},
+	}
+
+	status = tunnel.Status(context.Background())
+	if status.State != stateUnknown {
+		t.Errorf("Unexpected state, got %s", status.State)
+	}
+
+	if status.LastError != "failed" {
+		t.Errorf("Unexpected last error, got %s", status.LastError)
+	}
+}
+
+func TestStatusOrigin(t *testing.T) {
+	tests := []struct {
+		config   *TunnelConfig
+		expected string
+	}{
+		{&TunnelConfig{IP: "172.23.0.4", Port: "443", Protocol: protocolHTTPS}, "https://172.23.0.4:443"},
+		{&TunnelConfig{Protocol: protocolUnix, Socket: "/sockets/app.sock"}, "unix:/sockets/app.sock"},
+		{&TunnelConfig{IP: "172.23.0.4", Port: "22", Bastion: true}, "bastion"},
+		{&TunnelConfig{IP: "172.23.0.4", Port: "80", MaintenanceAddr: "127.0.0.1:8081"}, "172.23.0.4:80"},
+	}
+
+	for _, test := range tests {
+		if origin := statusOrigin(test.config); origin != test.expected {
+			t.Errorf("Expected origin %s, got %s", test.expected, origin)
+		}
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	tunnel := newTunnel()
+	tunnel.Service.Commander = &MockCommander{
+		mockRun: func() ([]byte, error) {
+			return []byte("false"), nil
+		},
+	}
+	registerTunnel(tunnel)
+
+	handler := http.NewServeMux()
+	registerStatusRoutes(handler)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/tunnels/site.tld", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code, got %d", recorder.Code)
+	}
+
+	var status TunnelStatus
+	err := json.NewDecoder(recorder.Body).Decode(&status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Hostname != "site.tld" || status.State != stateStopped {
+		t.Errorf("Unexpected status, got %+v", status)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/tunnels/missing.tld", nil))
+
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status code, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/tunnels", nil))
+
+	var statuses []TunnelStatus
+	err = json.NewDecoder(recorder.Body).Decode(&statuses)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(statuses) == 0 {
+		t.Error("Expected at least one tunnel status")
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/op/go-logging"
+)
+
+const (
+	// syslogEnterpriseID is the private enterprise number used for the structured data of
+	// messages, the number reserved for documentation by RFC 5612
+	syslogEnterpriseID = 32473
+
+	syslogDialTimeout  = 5 * time.Second
+	syslogWriteTimeout = 5 * time.Second
+	syslogRetryDelay   = 10 * time.Second
+)
+
+// Syslog severities as defined by RFC 5424
+const (
+	severityCritical = 2
+	severityError    = 3
+	severityWarning  = 4
+	severityNotice   = 5
+	severityInfo     = 6
+	severityDebug    = 7
+)
+
+// syslogFacilities maps facility names to their RFC 5424 codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var (
+	// tunnelLogForwarder forwards the output of every tunnel. Forwarding is disabled when it
+	// is nil.
+	tunnelLogForwarder *LogForwarder
+
+	logLinePatterns = []struct {
+		pattern  *regexp.Regexp
+		severity int
+	}{
+		{regexp.MustCompile(`(?i)level=(fatal|panic)|^\S*\s*(FTL|PNC)\s`), severityCritical},
+		{regexp.MustCompile(`(?i)level=error|^\S*\s*ERR\s`), severityError},
+		{regexp.MustCompile(`(?i)level=warn(ing)?|^\S*\s*WRN\s`), severityWarning},
+		{regexp.MustCompile(`(?i)level=debug|^\S*\s*DBG\s`), severityDebug},
+	}
+)
+
+// SyslogWriter sends messages to a syslog server in the RFC 5424 format over a Unix socket,
+// UDP or TCP. Messages sent over TCP are framed with their length as described in RFC 6587.
+// The connection is opened again if sending fails, waiting a while after a failed attempt so
+// that an unreachable server does not hold up logging. A server that stops reading is
+// treated the same once a write has not completed within WriteTimeout.
+type SyslogWriter struct {
+	Network      string
+	Addr         string
+	Facility     int
+	Hostname     string
+	WriteTimeout time.Duration
+
+	mu      sync.Mutex
+	conn    net.Conn
+	retryAt time.Time
+}
+
+// NewSyslogWriter returns a new SyslogWriter for an address such as "udp://logs:514",
+// "tcp://logs:601" or "unix:///dev/log".
+// An error is returned if the address or facility is invalid.
+func NewSyslogWriter(addr, facility string) (*SyslogWriter, error) {
+	parsed, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid syslog address %q", addr)
+	}
+
+	writer := &SyslogWriter{Network: parsed.Scheme, WriteTimeout: syslogWriteTimeout}
+
+	switch parsed.Scheme {
+	case "udp", "tcp":
+		writer.Addr = parsed.Host
+	case "unix":
+		writer.Addr = parsed.Path
+	default:
+		return nil, fmt.Errorf("Invalid syslog address %q, expected a udp://, tcp:// or unix:// address", addr)
+	}
+
+	if writer.Addr == "" {
+		return nil, fmt.Errorf("Invalid syslog address %q", addr)
+	}
+
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("Invalid syslog facility %q", facility)
+	}
+	writer.Facility = code
+
+	writer.Hostname, err = os.Hostname()
+	if err != nil {
+		writer.Hostname = "-"
+	}
+
+	return writer, nil
+}
+
+// Send sends a message with the given severity, app name and structured data, retrying once
+// on a new connection if sending fails. The connection is dropped without retrying if the
+// write times out.
+func (w *SyslogWriter) Send(severity int, appName, message string, data Fields) error {
+	msg := w.format(severity, appName, message, data, time.Now())
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var err error
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			if time.Now().Before(w.retryAt) {
+				return fmt.Errorf("Unable to connect to syslog at %s", w.Addr)
+			}
+
+			w.conn, err = w.dial()
+			if err != nil {
+				w.retryAt = time.Now().Add(syslogRetryDelay)
+				return err
+			}
+		}
+
+		err = w.conn.SetWriteDeadline(time.Now().Add(w.WriteTimeout))
+		if err == nil {
+			_, err = w.conn.Write(w.frame(msg))
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		w.conn.Close()
+		w.conn = nil
+
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			w.retryAt = time.Now().Add(syslogRetryDelay)
+			return err
+		}
+	}
+
+	return err
+}
+
+// Close closes the connection to the syslog server
+func (w *SyslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+
+	return err
+}
+
+func (w *SyslogWriter) dial() (net.Conn, error) {
+	if w.Network != "unix" {
+		return net.DialTimeout(w.Network, w.Addr, syslogDialTimeout)
+	}
+
+	conn, err := net.DialTimeout("unixgram", w.Addr, syslogDialTimeout)
+	if err == nil {
+		return conn, nil
+	}
+
+	return net.DialTimeout("unix", w.Addr, syslogDialTimeout)
+}
+
+// frame prefixes messages sent over TCP with their length
+func (w *SyslogWriter) frame(msg []byte) []byte {
+	if w.Network != "tcp" {
+		return msg
+	}
+
+	return append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+}
+
+// format returns a message in the RFC 5424 format
+func (w *SyslogWriter) format(severity int, appName, message string, data Fields, at time.Time) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %d - ",
+		w.Facility*8+severity,
+		at.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		syslogHeaderValue(w.Hostname, 255),
+		syslogHeaderValue(appName, 48),
+		os.Getpid(),
+	)
+
+	keys := data.keys()
+	if len(keys) == 0 {
+		buf.WriteString("-")
+	} else {
+		fmt.Fprintf(&buf, "[hera@%d", syslogEnterpriseID)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, " %s=\"%s\"", key, syslogParamEscaper.Replace(data[key]))
+		}
+		buf.WriteString("]")
+	}
+
+	buf.WriteString(" ")
+	buf.WriteString(message)
+
+	return buf.Bytes()
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogHeaderValue returns a header field that only contains printable characters and fits
+// within max characters, or "-" if it is empty
+func syslogHeaderValue(value string, max int) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, value)
+
+	if value == "" {
+		return "-"
+	}
+
+	if len(value) > max {
+		return value[:max]
+	}
+
+	return value
+}
+
+// SyslogBackend sends Hera's log records to a syslog server, with their fields as structured
+// data
+type SyslogBackend struct {
+	Writer  *SyslogWriter
+	AppName string
+	Redact  bool
+}
+
+// NewSyslogBackend returns a new SyslogBackend
+func NewSyslogBackend(writer *SyslogWriter, appName string, redact bool) *SyslogBackend {
+	backend := &SyslogBackend{
+		Writer:  writer,
+		AppName: appName,
+		Redact:  redact,
+	}
+
+	return backend
+}
+
+// Log sends a single record. Records that cannot be sent are dropped.
+func (b *SyslogBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	message, fields := splitFields(rec)
+
+	if b.Redact {
+		message = redact(message)
+		for key, value := range fields {
+			fields[key] = redact(value)
+		}
+	}
+
+	fields["module"] = rec.Module
+
+	return b.Writer.Send(levelSeverity(level), b.AppName, message, fields)
+}
+
+// levelSeverity returns the syslog severity for a log level
+func levelSeverity(level logging.Level) int {
+	switch level {
+	case logging.CRITICAL:
+		return severityCritical
+	case logging.ERROR:
+		return severityError
+	case logging.WARNING:
+		return severityWarning
+	case logging.NOTICE:
+		return severityNotice
+	case logging.DEBUG:
+		return severityDebug
+	}
+
+	return severityInfo
+}
+
+// logLineSeverity returns the syslog severity for a line of cloudflared output
+func logLineSeverity(line string) int {
+	for _, p := range logLinePatterns {
+		if p.pattern.MatchString(line) {
+			return p.severity
+		}
+	}
+
+	return severityInfo
+}
+
+// LogForwarder sends the output of tunnels to syslog, tagged with their hostname
+type LogForwarder struct {
+	Writer *SyslogWriter
+	Redact bool
+}
+
+// NewLogForwarder returns a new LogForwarder
+func NewLogForwarder(writer *SyslogWriter, redact bool) *LogForwarder {
+	forwarder := &LogForwarder{
+		Writer: writer,
+		Redact: redact,
+	}
+
+	return forwarder
+}
+
+// Forward sends a line of cloudflared output for the tunnel with the given hostname
+func (f *LogForwarder) Forward(hostname, line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+
+	message := line
+	if f.Redact {
+		message = redact(line)
+	}
+
+	err := f.Writer.Send(logLineSeverity(line), "cloudflared", message, Fields{fieldHostname: hostname})
+	if err != nil {
+		tunnelLog.Debugf("Unable to forward log line for %s: %s", hostname, err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	logging "github.com/op/go-logging"
+)
+
+func TestNewSyslogWriter(t *testing.T) {
+	writer, err := NewSyslogWriter("unix:///dev/log", "local3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if writer.Network != "unix" || writer.Addr != "/dev/log" || writer.Facility != 19 {
+		t.Errorf("Unexpected writer, got %+v", writer)
+	}
+
+	for _, addr := range []string{"logs:514", "http://logs:514", "udp://", "unix://"} {
+		_, err := NewSyslogWriter(addr, "daemon")
+		if err == nil {
+			t.Errorf("Expected error for address %s", addr)
+		}
+	}
+
+	_, err = NewSyslogWriter("udp://logs:514", "unknown")
+	if err == nil {
+		t.Error("Expected error for unknown facility")
+	}
+}
+
+func TestSyslogFormat(t *testing.T) {
+	writer := &SyslogWriter{Network: "tcp", Facility: 3, Hostname: "hera host"}
+	at := time.Date(2019, 4, 2, 18, 31, 5, 812000000, time.UTC)
+
+	msg := writer.format(severityError, "cloudflared", "lost connection", Fields{fieldHostname: `site.tld"]`}, at)
+
+	expected := fmt.Sprintf(`<27>1 2019-04-02T18:31:05.812000Z herahost cloudflared %d - [hera@32473 hostname="site.tld\"\]"] lost connection`, os.Getpid())
+	if string(msg) != expected {
+		t.Errorf("Unexpected message, got %s want %s", msg, expected)
+	}
+
+	framed := string(writer.frame([]byte("message")))
+	if framed != "7 message" {
+		t.Errorf("Unexpected frame, got %q", framed)
+	}
+
+	msg = writer.format(severityInfo, "", "started", nil, at)
+	if !strings.Contains(string(msg), " herahost - ") || !strings.HasSuffix(string(msg), " - - started") {
+		t.Errorf("Expected nil values for empty fields, got %s", msg)
+	}
+}
+
+func TestSyslogBackend(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	writer, err := NewSyslogWriter("udp://"+conn.LocalAddr().String(), "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	defer resetLogBackends()
+	setLogBackends([]logging.Backend{NewSyslogBackend(writer, "hera", true)}, DefaultConfig().LogOptions())
+
+	withFields(tunnelLog, Fields{fieldHostname: "site.tld"}).Warningf("Unable to read %s", "/certs/site.tld.pem")
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<28>1 ") || !strings.HasSuffix(msg, `[hera@32473 hostname="site.tld" module="tunnel"] Unable to read /certs/[REDACTED]`) {
+		t.Errorf("Unexpected message, got %s", msg)
+	}
+}
+
+func TestSyslogWriteTimeout(t *testing.T) {
+	writer, err := NewSyslogWriter("tcp://127.0.0.1:1", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a server that never reads blocks writes on the other end of the pipe
+	client, server := net.Pipe()
+	defer server.Close()
+
+	writer.conn = client
+	writer.WriteTimeout = 10 * time.Millisecond
+
+	done := make(chan error, 1)
+	go func() {
+		done <- writer.Send(severityInfo, "hera", "message", nil)
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected send to time out")
+	}
+
+	if err == nil {
+		t.Error("Expected error when the write times out")
+	}
+
+	if writer.conn != nil {
+		t.Error("Expected connection to be dropped after the write timed out")
+	}
+
+	if !writer.retryAt.After(time.Now()) {
+		t.Error("Expected reconnecting to be delayed after the write timed out")
+	}
+}
+
+func TestLogLineSeverity(t *testing.T) {
+	tests := map[string]int{
+		`time="2019-04-02T18:31:05Z" level=error msg="Lost connection"`: severityError,
+		`time="2019-04-02T18:31:05Z" level=warning msg="Retrying"`:      severityWarning,
+		`time="2019-04-02T18:31:05Z" level=info msg="Connected to LAX"`: severityInfo,
+		`2019-04-02T18:31:05Z FTL Unable to read origin cert`:           severityCritical,
+		`2019-04-02T18:31:05Z DBG Sending heartbeat`:                    severityDebug,
+	}
+
+	for line, expected := range tests {
+		if logLineSeverity(line) != expected {
+			t.Errorf("Unexpected severity for %q, got %d want %d", line, logLineSeverity(line), expected)
+		}
+	}
+}
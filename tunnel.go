@@ -1,15 +1,38 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/afero"
 )
 
+const (
+	outcomeAdopted   = "adopted"
+	outcomeRestarted = "restarted"
+	outcomeStarted   = "started"
+	outcomeWaiting   = "waiting"
+
+	// adoptedLogBacklog is how much of the log of an adopted tunnel is read to find its
+	// current connection status
+	adoptedLogBacklog = 64 * 1024
+)
+
 var (
-	registry = make(map[string]*Tunnel)
+	registry   = make(map[string]*Tunnel)
+	registryMu sync.RWMutex
+
+	// tunnelOperations serializes operations that start or stop tunnels, which can be requested
+	// by container events and the control socket at the same time. It is released while a
+	// tunnel is handed over, see awaitHandover.
+	tunnelOperations sync.Mutex
 )
 
 // Tunnel holds the corresponding config, certificate, and service for a tunnel
@@ -17,13 +40,37 @@ type Tunnel struct {
 	Config      *TunnelConfig
 	Certificate *Certificate
 	Service     *Service
+
+	mu               sync.Mutex
+	lastError        error
+	connection       ConnectionStatus
+	logWatcher       *LogWatcher
+	crashLoop        CrashLoopStatus
+	crashLoopMonitor *CrashLoopMonitor
+	certDigest       string
 }
 
 // TunnelConfig holds the necessary configuration for a tunnel
 type TunnelConfig struct {
-	IP       string
-	Hostname string
-	Port     string
+	IP          string
+	Hostname    string
+	Port        string
+	ContainerID string
+	MetricsAddr string
+
+	// Protocol is the protocol of the origin, with Socket holding the path of Unix socket
+	// origins. Bastion tunnels let clients choose the host to connect to over SSH.
+	Protocol string
+	Socket   string
+	Bastion  bool
+	Origin   *OriginOptions
+
+	// Settings are the cloudflared settings set with hera.cloudflared.* labels
+	Settings map[string]string
+
+	// MaintenanceAddr is the address of the maintenance page the tunnel points at instead of
+	// its container while the container is down
+	MaintenanceAddr string
 }
 
 // NewTunnel returns a Tunnel with its corresponding config and certificate
@@ -42,7 +89,9 @@ func NewTunnel(config *TunnelConfig, certificate *Certificate) *Tunnel {
 // GetTunnelForHost returns the tunnel for a given hostname.
 // An error is returned if a tunnel is not found.
 func GetTunnelForHost(hostname string) (*Tunnel, error) {
+	registryMu.RLock()
 	tunnel, ok := registry[hostname]
+	registryMu.RUnlock()
 
 	if !ok {
 		return nil, fmt.Errorf("No tunnel exists for %s", hostname)
@@ -51,33 +100,448 @@ func GetTunnelForHost(hostname string) (*Tunnel, error) {
 	return tunnel, nil
 }
 
-// Start starts a tunnel
-func (t *Tunnel) Start() error {
-	err := t.prepareService()
+// AllTunnels returns every registered tunnel sorted by hostname
+func AllTunnels() []*Tunnel {
+	registryMu.RLock()
+	tunnels := make([]*Tunnel, 0, len(registry))
+	for _, tunnel := range registry {
+		tunnels = append(tunnels, tunnel)
+	}
+	registryMu.RUnlock()
+
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].Config.Hostname < tunnels[j].Config.Hostname
+	})
+
+	return tunnels
+}
+
+// registerTunnel adds a tunnel to the registry, replacing any tunnel with the same hostname
+func registerTunnel(tunnel *Tunnel) {
+	registryMu.Lock()
+	registry[tunnel.Config.Hostname] = tunnel
+	registryMu.Unlock()
+}
+
+// Start starts a tunnel. The tunnel is registered even if it fails to start so that
+// the failure can be reported.
+func (t *Tunnel) Start(ctx context.Context) error {
+	awaitHandover(t.Config.Hostname)
+
+	previous, err := GetTunnelForHost(t.Config.Hostname)
+	if err == nil && previous != t {
+		previous.release()
+	}
+
+	registerTunnel(t)
+
+	err = t.prepareService()
+	if err == nil {
+		t.watchLog(0)
+		err = t.startService(ctx)
+	}
+
+	if err == nil {
+		metrics.TunnelStarts.Inc(t.Config.Hostname)
+		t.monitorCrashLoops()
+		notify(eventTunnelStarted, t.Config, nil)
+	} else {
+		notify(eventTunnelFailed, t.Config, err)
+	}
+
+	t.setLastError(err)
+
+	return err
+}
+
+// Adopt takes over the service of a tunnel that is already running with the same config
+// instead of restarting it. Otherwise the tunnel is started as usual. It returns whether the
+// tunnel was adopted, restarted or started.
+func (t *Tunnel) Adopt(ctx context.Context) (string, error) {
+	awaitHandover(t.Config.Hostname)
+
+	err := t.allocateMetricsAddr()
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	err = t.startService()
+	supervised, err := t.Service.IsSupervised()
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	registry[t.Config.Hostname] = t
+	running := false
+	if supervised {
+		running, err = t.Service.IsRunning(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
 
-	return nil
+	if !running || !t.filesMatch() {
+		outcome := outcomeStarted
+		if running {
+			outcome = outcomeRestarted
+		}
+
+		return outcome, t.Start(ctx)
+	}
+
+	t.logger().With(fieldTunnelState, stateRunning).Infof("Adopting running tunnel %s", t.Config.Hostname)
+
+	previous, err := GetTunnelForHost(t.Config.Hostname)
+	if err == nil && previous != t {
+		previous.release()
+	}
+
+	registerTunnel(t)
+	t.recordCertificate()
+	t.watchLog(adoptedLogBacklog)
+	t.monitorCrashLoops()
+	t.setLastError(nil)
+
+	return outcomeAdopted, nil
 }
 
 // Stop stops a tunnel
-func (t *Tunnel) Stop() error {
-	log.Infof("Stopping tunnel %s", t.Config.Hostname)
+func (t *Tunnel) Stop(ctx context.Context) error {
+	awaitHandover(t.Config.Hostname)
+
+	t.logger().With(fieldTunnelState, stateStopped).Infof("Stopping tunnel %s", t.Config.Hostname)
+
+	t.stopMonitoringCrashLoops()
+
+	err := t.Service.Stop(ctx)
+	if err == nil {
+		metrics.TunnelStops.Inc(t.Config.Hostname)
+		t.stopWatchingLog()
+		t.releaseMetricsAddr()
+		notify(eventTunnelStopped, t.Config, nil)
+	} else {
+		notify(eventTunnelFailed, t.Config, err)
+	}
+
+	t.setLastError(err)
+
+	return err
+}
+
+// UpdateOrigin points the tunnel at the origin in config, keeping the tunnel running if the
+// address of the origin has not changed and restarting its service with a handover otherwise.
+// It returns whether the service was restarted.
+func (t *Tunnel) UpdateOrigin(ctx context.Context, config *TunnelConfig) (bool, error) {
+	awaitHandover(t.Config.Hostname)
+
+	t.updateConfig(func(c *TunnelConfig) {
+		c.ContainerID = config.ContainerID
+	})
+
+	if sameOrigin(config, t.Config) {
+		t.logger().Infof("Container for %s has started again, keeping tunnel", t.Config.Hostname)
+		return false, nil
+	}
+
+	t.logger().Infof("Origin for %s has moved to %s, switching tunnel", t.Config.Hostname, config.originURL())
+
+	t.updateConfig(func(c *TunnelConfig) {
+		c.IP = config.IP
+		c.Port = config.Port
+		c.Protocol = config.Protocol
+		c.Socket = config.Socket
+		c.Bastion = config.Bastion
+		c.Origin = config.Origin
+		c.Settings = config.Settings
+	})
+
+	err := t.writeConfigFile()
+	if err == nil {
+		err = t.restartWithHandover(ctx)
+	}
+
+	t.setLastError(err)
+
+	return true, err
+}
+
+// EnterMaintenance points the tunnel at the maintenance page served on addr instead of its
+// container. The tunnel points at its container again when it is started for a new container.
+func (t *Tunnel) EnterMaintenance(ctx context.Context, addr string) error {
+	awaitHandover(t.Config.Hostname)
+
+	t.logger().With(fieldTunnelState, stateMaintenance).Infof("Serving maintenance page for %s", t.Config.Hostname)
+
+	t.updateConfig(func(c *TunnelConfig) {
+		c.MaintenanceAddr = addr
+	})
+
+	err := t.writeConfigFile()
+	if err == nil {
+		err = t.startService(ctx)
+	}
 
-	err := t.Service.Stop()
 	if err != nil {
-		return err
+		notify(eventTunnelFailed, t.Config, err)
 	}
 
-	return nil
+	t.setLastError(err)
+
+	return err
+}
+
+// InMaintenance returns true if the tunnel points at the maintenance page
+func (t *Tunnel) InMaintenance() bool {
+	return t.config().MaintenanceAddr != ""
+}
+
+// config returns a copy of the tunnel config for reading outside of tunnelOperations, while the
+// tunnel may be updated
+func (t *Tunnel) config() TunnelConfig {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return *t.Config
+}
+
+// updateConfig changes the tunnel config. The config is only changed while holding
+// tunnelOperations, and update runs under the lock that config takes.
+func (t *Tunnel) updateConfig(update func(*TunnelConfig)) {
+	t.mu.Lock()
+	update(t.Config)
+	t.mu.Unlock()
+}
+
+// LastError returns the error from the most recent start or stop of the tunnel, if any
+func (t *Tunnel) LastError() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.lastError
+}
+
+// setLastError records the outcome of the most recent start or stop of the tunnel
+func (t *Tunnel) setLastError(err error) {
+	t.mu.Lock()
+	t.lastError = err
+	t.mu.Unlock()
+}
+
+// Connection returns the connection status of the tunnel as reported by cloudflared
+func (t *Tunnel) Connection() ConnectionStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.connection.State == "" {
+		return ConnectionStatus{State: connectionUnknown}
+	}
+
+	return t.connection
+}
+
+// watchLog follows the tunnel log file to keep track of the connection status, starting with
+// the given number of bytes already in the file. Any existing watcher is replaced.
+func (t *Tunnel) watchLog(backlog int64) {
+	t.stopWatchingLog()
+
+	watcher := NewLogWatcher(t.Service.LogFilePath(), fs)
+	watcher.Rewind(backlog)
+
+	t.mu.Lock()
+	t.logWatcher = watcher
+	t.connection = ConnectionStatus{State: connectionConnecting, UpdatedAt: time.Now()}
+	t.mu.Unlock()
+
+	watcher.Start(t.handleLogLine)
+}
+
+// stopWatchingLog stops following the tunnel log file and marks the tunnel as disconnected
+func (t *Tunnel) stopWatchingLog() {
+	t.mu.Lock()
+	watcher := t.logWatcher
+	t.logWatcher = nil
+	t.mu.Unlock()
+
+	if watcher == nil {
+		return
+	}
+
+	watcher.Stop()
+
+	t.mu.Lock()
+	t.connection = ConnectionStatus{State: connectionDisconnected, UpdatedAt: time.Now()}
+	t.mu.Unlock()
+}
+
+// handleLogLine updates the connection status from a line of the tunnel log
+func (t *Tunnel) handleLogLine(line string) {
+	t.forwardLogLine(line)
+
+	kind, message := parseLogLine(line)
+	if kind == logEventNone {
+		return
+	}
+
+	t.mu.Lock()
+	previous := t.connection.State
+	t.connection.apply(kind, message, time.Now())
+	current := t.connection.State
+	t.mu.Unlock()
+
+	if previous == current {
+		return
+	}
+
+	switch current {
+	case connectionConnected:
+		t.logger().Infof("Tunnel %s is connected", t.Config.Hostname)
+		notify(eventTunnelConnected, t.Config, nil)
+	case connectionFailed:
+		t.logger().With(fieldTunnelState, stateFailed).Errorf("Tunnel %s has failed: %s", t.Config.Hostname, message)
+		notify(eventTunnelFailed, t.Config, errors.New(message))
+	default:
+		t.logger().Warningf("Tunnel %s is %s: %s", t.Config.Hostname, current, message)
+	}
+}
+
+// forwardLogLine sends a line of the tunnel log to syslog if forwarding is enabled, skipping
+// lines that were already in the log when the tunnel was adopted
+func (t *Tunnel) forwardLogLine(line string) {
+	if tunnelLogForwarder == nil {
+		return
+	}
+
+	t.mu.Lock()
+	watcher := t.logWatcher
+	t.mu.Unlock()
+
+	if watcher != nil && watcher.Replaying() {
+		return
+	}
+
+	tunnelLogForwarder.Forward(t.Config.Hostname, line)
+}
+
+// CrashLoop returns the restarts of the tunnel service and whether it is crash looping
+func (t *Tunnel) CrashLoop() CrashLoopStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.crashLoop
+}
+
+// monitorCrashLoops watches the tunnel service for crash loops, replacing any existing monitor
+func (t *Tunnel) monitorCrashLoops() {
+	if crashLoopPolicy == nil {
+		return
+	}
+
+	t.stopMonitoringCrashLoops()
+
+	monitor := NewCrashLoopMonitor(t, crashLoopPolicy)
+
+	t.mu.Lock()
+	t.crashLoopMonitor = monitor
+	t.crashLoop = CrashLoopStatus{}
+	t.mu.Unlock()
+
+	monitor.Start()
+}
+
+// stopMonitoringCrashLoops stops watching the tunnel service for crash loops
+func (t *Tunnel) stopMonitoringCrashLoops() {
+	t.mu.Lock()
+	monitor := t.crashLoopMonitor
+	t.crashLoopMonitor = nil
+	t.mu.Unlock()
+
+	if monitor != nil {
+		monitor.Stop()
+	}
+}
+
+// setRestarts records the number of times the tunnel service has restarted
+func (t *Tunnel) setRestarts(restarts int) {
+	t.mu.Lock()
+	t.crashLoop.Restarts = restarts
+	t.mu.Unlock()
+}
+
+// setCrashLoop marks the tunnel as failed because its service is crash looping
+func (t *Tunnel) setCrashLoop(reason string, retryAt time.Time) {
+	t.mu.Lock()
+	t.crashLoop.Failed = true
+	t.crashLoop.Reason = reason
+	t.crashLoop.RetryAt = retryAt
+	t.lastError = fmt.Errorf("Crash looping: %s", reason)
+	t.mu.Unlock()
+}
+
+// clearCrashLoop marks the tunnel as no longer failed once its service is tried again
+func (t *Tunnel) clearCrashLoop() {
+	t.mu.Lock()
+	t.crashLoop.Failed = false
+	t.crashLoop.RetryAt = time.Time{}
+	t.lastError = nil
+	t.mu.Unlock()
+}
+
+// ReloadCertificate finds the certificate for the tunnel again and restarts the tunnel if a
+// different certificate was found or the certificate file has changed since the tunnel was
+// started. It returns whether the tunnel was restarted.
+func (t *Tunnel) ReloadCertificate(ctx context.Context) (bool, error) {
+	awaitHandover(t.Config.Hostname)
+
+	cert, err := getCertificate(t.Config.Hostname)
+	if err != nil {
+		return false, err
+	}
+
+	digest, err := cert.Digest()
+	if err != nil {
+		return false, err
+	}
+
+	t.mu.Lock()
+	unchanged := cert.Name == t.Certificate.Name && digest == t.certDigest
+	t.mu.Unlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	t.logger().Infof("Certificate for %s has changed, restarting tunnel", t.Config.Hostname)
+	t.Certificate = cert
+
+	return true, t.Start(ctx)
+}
+
+// recordCertificate remembers the digest of the certificate the tunnel is started with
+func (t *Tunnel) recordCertificate() {
+	digest, err := t.Certificate.Digest()
+	if err != nil {
+		t.logger().Debugf("Unable to read certificate %s: %s", t.Certificate.FullPath(), err)
+	}
+
+	t.mu.Lock()
+	t.certDigest = digest
+	t.mu.Unlock()
+}
+
+// logger returns a logger with the hostname and container of the tunnel as fields
+func (t *Tunnel) logger() *FieldLogger {
+	config := t.config()
+
+	fields := Fields{
+		fieldHostname:    config.Hostname,
+		fieldContainerID: shortID(config.ContainerID),
+	}
+
+	return withFields(tunnelLog, fields)
+}
+
+// release stops every background task of the tunnel without stopping its service
+func (t *Tunnel) release() {
+	t.stopMonitoringCrashLoops()
+	t.stopWatchingLog()
 }
 
 // prepareService creates the service and necessary files for the tunnel service
@@ -87,6 +551,11 @@ func (t *Tunnel) prepareService() error {
 		return err
 	}
 
+	err = t.allocateMetricsAddr()
+	if err != nil {
+		return err
+	}
+
 	err = t.writeConfigFile()
 	if err != nil {
 		return err
@@ -97,42 +566,76 @@ func (t *Tunnel) prepareService() error {
 		return err
 	}
 
+	t.recordCertificate()
+
 	return nil
 }
 
+// allocateMetricsAddr assigns the tunnel a local address for the cloudflared metrics server,
+// keeping the address from the existing config file where possible
+func (t *Tunnel) allocateMetricsAddr() error {
+	if tunnelMetricsPorts == nil || t.Config.MetricsAddr != "" {
+		return nil
+	}
+
+	addr, err := tunnelMetricsPorts.Allocate(t.Config.Hostname, t.currentConfigValue("metrics"))
+	if err != nil {
+		return err
+	}
+
+	t.updateConfig(func(c *TunnelConfig) {
+		c.MetricsAddr = addr
+	})
+
+	return nil
+}
+
+// releaseMetricsAddr frees the metrics address of a stopped tunnel for other tunnels. The
+// address is allocated again, preferably the same one, when the tunnel is started.
+func (t *Tunnel) releaseMetricsAddr() {
+	if tunnelMetricsPorts == nil || t.Config.MetricsAddr == "" {
+		return
+	}
+
+	tunnelMetricsPorts.Release(t.Config.Hostname)
+	t.updateConfig(func(c *TunnelConfig) {
+		c.MetricsAddr = ""
+	})
+}
+
 // startService starts the tunnel service
-func (t *Tunnel) startService() error {
+func (t *Tunnel) startService(ctx context.Context) error {
 	supervised, err := t.Service.IsSupervised()
 	if err != nil {
 		return err
 	}
 
 	if !supervised {
-		log.Infof("Registering tunnel %s", t.Config.Hostname)
+		t.logger().Infof("Registering tunnel %s", t.Config.Hostname)
 
-		err := t.Service.Supervise()
+		err := t.Service.Supervise(ctx)
 		if err != nil {
 			return err
 		}
 		return nil
 	}
 
-	running, err := t.Service.IsRunning()
+	running, err := t.Service.IsRunning(ctx)
 	if err != nil {
 		return err
 	}
 
 	if running {
-		log.Infof("Restarting tunnel %s", t.Config.Hostname)
+		t.logger().Infof("Restarting tunnel %s", t.Config.Hostname)
 
-		err := t.Service.Restart()
+		err := t.Service.Restart(ctx)
 		if err != nil {
 			return err
 		}
 	} else {
-		log.Infof("Starting tunnel %s", t.Config.Hostname)
+		t.logger().Infof("Starting tunnel %s", t.Config.Hostname)
 
-		err := t.Service.Start()
+		err := t.Service.Start(ctx)
 		if err != nil {
 			return err
 		}
@@ -141,17 +644,99 @@ func (t *Tunnel) startService() error {
 	return nil
 }
 
-// writeConfigFile creates the config file for a tunnel
-func (t *Tunnel) writeConfigFile() error {
-	configLines := []string{
-		"hostname: %s",
-		"url: %s:%s",
-		"logfile: %s",
-		"origincert: %s",
-		"no-autoupdate: true",
+// configEntry is a single setting in a tunnel config file
+type configEntry struct {
+	Key   string
+	Value string
+}
+
+// configEntries returns the settings of the tunnel config file in the order they are written
+func (t *Tunnel) configEntries() []configEntry {
+	config := t.config()
+	inMaintenance := config.MaintenanceAddr != ""
+
+	entries := []configEntry{
+		{"hostname", config.Hostname},
+	}
+
+	if url := config.originURL(); url != "" {
+		entries = append(entries, configEntry{"url", url})
+	}
+
+	if config.Bastion && !inMaintenance {
+		entries = append(entries, configEntry{"bastion", "true"})
+	}
+
+	if !inMaintenance {
+		entries = append(entries, config.Origin.configEntries()...)
+	}
+
+	entries = append(entries, cloudflaredEntries(config.Settings)...)
+
+	entries = append(entries,
+		configEntry{"logfile", t.Service.LogFilePath()},
+		configEntry{"origincert", t.Certificate.FullPath()},
+		configEntry{"no-autoupdate", "true"},
+	)
+
+	if config.MetricsAddr != "" {
+		entries = append(entries, configEntry{"metrics", config.MetricsAddr})
+	}
+
+	return entries
+}
+
+// renderConfig returns the contents of the tunnel config file
+func (t *Tunnel) renderConfig() string {
+	var configLines []string
+	for _, entry := range t.configEntries() {
+		configLines = append(configLines, fmt.Sprintf("%s: %s", entry.Key, settingValue(entry.Value)))
+	}
+
+	return strings.Join(configLines, "\n")
+}
+
+// currentConfigValue returns the value of a setting in the config file currently on disk,
+// or an empty string if the file or setting does not exist
+func (t *Tunnel) currentConfigValue(key string) string {
+	contents, err := afero.ReadFile(fs, t.Service.ConfigFilePath())
+	if err != nil {
+		return ""
+	}
+
+	prefix := key + ": "
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			value := strings.TrimPrefix(line, prefix)
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				return unquoted
+			}
+
+			return value
+		}
 	}
 
-	contents := fmt.Sprintf(strings.Join(configLines[:], "\n"), t.Config.Hostname, t.Config.IP, t.Config.Port, t.Service.LogFilePath(), t.Certificate.FullPath())
+	return ""
+}
+
+// filesMatch returns true if the config and run files on disk are the ones the tunnel would write
+func (t *Tunnel) filesMatch() bool {
+	config, err := afero.ReadFile(fs, t.Service.ConfigFilePath())
+	if err != nil || string(config) != t.renderConfig() {
+		return false
+	}
+
+	run, err := afero.ReadFile(fs, t.Service.RunFilePath())
+	if err != nil || string(run) != t.renderRunFile() {
+		return false
+	}
+
+	return true
+}
+
+// writeConfigFile creates the config file for a tunnel
+func (t *Tunnel) writeConfigFile() error {
+	contents := t.renderConfig()
 
 	err := afero.WriteFile(fs, t.Service.ConfigFilePath(), []byte(contents), 0644)
 	if err != nil {
@@ -161,13 +746,19 @@ func (t *Tunnel) writeConfigFile() error {
 	return nil
 }
 
-// writeRunFile creates the run file for a tunnel
-func (t *Tunnel) writeRunFile() error {
+// renderRunFile returns the contents of the tunnel run file
+func (t *Tunnel) renderRunFile() string {
 	runLines := []string{
 		"#!/bin/sh",
 		"exec cloudflared --config %s",
 	}
-	contents := fmt.Sprintf(strings.Join(runLines[:], "\n"), t.Service.ConfigFilePath())
+
+	return fmt.Sprintf(strings.Join(runLines[:], "\n"), t.Service.ConfigFilePath())
+}
+
+// writeRunFile creates the run file for a tunnel
+func (t *Tunnel) writeRunFile() error {
+	contents := t.renderRunFile()
 
 	err := afero.WriteFile(fs, t.Service.RunFilePath(), []byte(contents), os.ModePerm)
 	if err != nil {
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 
     "github.com/spf13/afero"
@@ -17,6 +21,13 @@ func newTunnel() *Tunnel {
 	return NewTunnel(config, cert)
 }
 
+// unregisterTunnel removes a tunnel registered by a test
+func unregisterTunnel(tunnel *Tunnel) {
+	registryMu.Lock()
+	delete(registry, tunnel.Config.Hostname)
+	registryMu.Unlock()
+}
+
 func TestWriteConfigFile(t *testing.T) {
 	fs = afero.NewMemMapFs()
 	tunnel := newTunnel()
@@ -54,3 +65,141 @@ func TestWriteRunFile(t *testing.T) {
 		t.Error("Expected run to exist")
 	}
 }
+
+func TestRenderConfig(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	tunnel := newTunnel()
+	tunnel.Config.MetricsAddr = "127.0.0.1:41000"
+
+	expected := strings.Join([]string{
+		"hostname: site.tld",
+		"url: 172.23.0.4:80",
+		"logfile: /var/log/hera/site.tld.log",
+		"origincert: /certs/site.tld.pem",
+		"no-autoupdate: true",
+		"metrics: 127.0.0.1:41000",
+	}, "\n")
+
+	actual := tunnel.renderConfig()
+	if actual != expected {
+		t.Errorf("Unexpected config, got:\n%s", actual)
+	}
+
+	err := tunnel.writeConfigFile()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if value := tunnel.currentConfigValue("metrics"); value != "127.0.0.1:41000" {
+		t.Errorf("Unexpected metrics address, got %s", value)
+	}
+}
+
+type StatusCommander struct {
+	status   string
+	commands *[]string
+}
+
+func (c StatusCommander) Run(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	*c.commands = append(*c.commands, name)
+
+	if name == "s6-svstat" {
+		return []byte(c.status), nil
+	}
+
+	return nil, nil
+}
+
+func TestAdopt(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	tunnel := newTunnel()
+	defer tunnel.release()
+
+	var commands []string
+	tunnel.Service.Commander = StatusCommander{status: "true true 12 -1 NA 300", commands: &commands}
+
+	tunnel.Service.Create()
+	fs.Mkdir(tunnel.Service.supervisePath(), os.ModePerm)
+	tunnel.writeConfigFile()
+	tunnel.writeRunFile()
+
+	outcome, err := tunnel.Adopt(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if outcome != outcomeAdopted {
+		t.Errorf("Expected tunnel to be adopted, got %s", outcome)
+	}
+
+	for _, command := range commands {
+		if command != "s6-svstat" {
+			t.Errorf("Unexpected command %s for adopted tunnel", command)
+		}
+	}
+
+	changed := newTunnel()
+	defer changed.release()
+
+	changed.Config.Port = "8080"
+	changed.Service.Commander = tunnel.Service.Commander
+
+	outcome, err = changed.Adopt(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if outcome != outcomeRestarted {
+		t.Errorf("Expected tunnel to be restarted, got %s", outcome)
+	}
+}
+
+func TestStopReleasesMetricsAddr(t *testing.T) {
+	fs = afero.NewMemMapFs()
+
+	allocator, err := NewPortAllocator("127.0.0.1", "41000-41000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tunnelMetricsPorts = allocator
+	defer func() { tunnelMetricsPorts = nil }()
+
+	tunnel := newTunnel()
+	tunnel.Service.Commander = MockCommander{mockRun: func() ([]byte, error) { return nil, nil }}
+
+	err = tunnel.allocateMetricsAddr()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registerTunnel(tunnel)
+	defer unregisterTunnel(tunnel)
+
+	// metrics are scraped while the tunnel stops
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writeTunnelMetrics(ioutil.Discard)
+	}()
+
+	err = tunnel.Stop(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-done
+
+	if tunnel.Config.MetricsAddr != "" {
+		t.Errorf("Expected metrics address to be released, got %s", tunnel.Config.MetricsAddr)
+	}
+
+	addr, err := allocator.Allocate("other.tld", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addr != "127.0.0.1:41000" {
+		t.Errorf("Expected released address, got %s", addr)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tunnelMetricsHost    = "127.0.0.1"
+	tunnelMetricsTimeout = 2 * time.Second
+
+	// exportedHostnameLabel replaces a hostname label set by cloudflared, which would clash with
+	// the hostname label added to every sample
+	exportedHostnameLabel = "exported_hostname"
+)
+
+// tunnelMetricsPorts allocates the local addresses cloudflared serves its metrics on.
+// Tunnel metrics are disabled when it is nil.
+var tunnelMetricsPorts *PortAllocator
+
+// PortAllocator assigns each hostname a unique local port from a range
+type PortAllocator struct {
+	Host  string
+	First int
+	Last  int
+
+	mu       sync.Mutex
+	assigned map[string]int
+}
+
+// NewPortAllocator returns a new PortAllocator for a port range such as "41000-41999".
+// An error is returned if the range cannot be parsed.
+func NewPortAllocator(host, portRange string) (*PortAllocator, error) {
+	bounds := strings.SplitN(portRange, "-", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("Invalid port range %q", portRange)
+	}
+
+	first, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid port range %q", portRange)
+	}
+
+	last, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
+	if err != nil || first < 1 || last > 65535 || first > last {
+		return nil, fmt.Errorf("Invalid port range %q", portRange)
+	}
+
+	allocator := &PortAllocator{
+		Host:     host,
+		First:    first,
+		Last:     last,
+		assigned: make(map[string]int),
+	}
+
+	return allocator, nil
+}
+
+// Allocate returns the address assigned to a hostname, assigning a free port if it has none.
+// The preferred address is used when it is within the range and not taken by another hostname,
+// which keeps the port of an existing tunnel stable across restarts.
+// An error is returned if every port in the range is taken.
+func (p *PortAllocator) Allocate(hostname, preferred string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if port, ok := p.assigned[hostname]; ok {
+		return p.addr(port), nil
+	}
+
+	taken := make(map[int]bool)
+	for _, port := range p.assigned {
+		taken[port] = true
+	}
+
+	if port, ok := p.parsePreferred(preferred); ok && !taken[port] {
+		p.assigned[hostname] = port
+		return p.addr(port), nil
+	}
+
+	for port := p.First; port <= p.Last; port++ {
+		if taken[port] || !portAvailable(p.addr(port)) {
+			continue
+		}
+
+		p.assigned[hostname] = port
+		return p.addr(port), nil
+	}
+
+	return "", fmt.Errorf("No free metrics port for %s in %d-%d", hostname, p.First, p.Last)
+}
+
+// Release frees the port assigned to a hostname so that it can be assigned to another hostname
+func (p *PortAllocator) Release(hostname string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.assigned, hostname)
+}
+
+func (p *PortAllocator) parsePreferred(preferred string) (int, bool) {
+	host, portValue, err := net.SplitHostPort(preferred)
+	if err != nil || host != p.Host {
+		return 0, false
+	}
+
+	port, err := strconv.Atoi(portValue)
+	if err != nil || port < p.First || port > p.Last {
+		return 0, false
+	}
+
+	return port, true
+}
+
+func (p *PortAllocator) addr(port int) string {
+	return net.JoinHostPort(p.Host, strconv.Itoa(port))
+}
+
+// portAvailable returns true if nothing is listening on the given address
+func portAvailable(addr string) bool {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return false
+	}
+	listener.Close()
+
+	return true
+}
+
+// writeTunnelMetrics scrapes the metrics of every tunnel and writes them with a hostname label
+func writeTunnelMetrics(w io.Writer) {
+	type scrape struct {
+		hostname string
+		body     []byte
+		err      error
+	}
+
+	var scrapes []*scrape
+	var wg sync.WaitGroup
+
+	for _, tunnel := range AllTunnels() {
+		config := tunnel.config()
+		if config.MetricsAddr == "" {
+			continue
+		}
+
+		s := &scrape{hostname: config.Hostname}
+		scrapes = append(scrapes, s)
+
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			s.body, s.err = scrapeMetrics(addr)
+		}(config.MetricsAddr)
+	}
+
+	wg.Wait()
+
+	families := newMetricFamilies()
+	var up []string
+
+	for _, s := range scrapes {
+		labels := formatLabels([]string{"hostname"}, []string{s.hostname})
+
+		if s.err != nil {
+			tunnelLog.Debugf("Unable to scrape metrics for %s: %s", s.hostname, s.err)
+			up = append(up, fmt.Sprintf("hera_tunnel_metrics_up%s 0", labels))
+			continue
+		}
+
+		up = append(up, fmt.Sprintf("hera_tunnel_metrics_up%s 1", labels))
+		families.add(s.body, s.hostname)
+	}
+
+	writeLines(w, "hera_tunnel_metrics_up", "Whether the metrics of a tunnel could be scraped.", "gauge", up)
+	families.write(w)
+}
+
+// scrapeMetrics returns the body of the metrics endpoint served by cloudflared at addr
+func scrapeMetrics(addr string) ([]byte, error) {
+	client := &http.Client{Timeout: tunnelMetricsTimeout}
+
+	resp, err := client.Get(fmt.Sprintf("http://%s/metrics", addr))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, resp.Body)
+
+	return buf.Bytes(), err
+}
+
+// metricFamilies merges metrics in the Prometheus text format from several sources,
+// keeping the samples of each metric together under a single HELP and TYPE line
+type metricFamilies struct {
+	order  []string
+	byName map[string]*metricFamily
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	samples []string
+}
+
+func newMetricFamilies() *metricFamilies {
+	return &metricFamilies{byName: make(map[string]*metricFamily)}
+}
+
+func (m *metricFamilies) get(name string) *metricFamily {
+	family, ok := m.byName[name]
+	if !ok {
+		family = &metricFamily{name: name}
+		m.byName[name] = family
+		m.order = append(m.order, name)
+	}
+
+	return family
+}
+
+// add parses metrics in the Prometheus text format and adds a hostname label to each sample,
+// renaming a hostname label already on a sample to exported_hostname
+func (m *metricFamilies) add(body []byte, hostname string) {
+	var current *metricFamily
+	label := formatLabels([]string{"hostname"}, []string{hostname})
+	label = label[1 : len(label)-1]
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) < 3 || (fields[1] != "HELP" && fields[1] != "TYPE") {
+				continue
+			}
+
+			current = m.get(fields[2])
+
+			value := ""
+			if len(fields) == 4 {
+				value = fields[3]
+			}
+
+			if fields[1] == "HELP" && current.help == "" {
+				current.help = value
+			} else if fields[1] == "TYPE" && current.kind == "" {
+				current.kind = value
+			}
+
+			continue
+		}
+
+		end := strings.IndexAny(line, "{ ")
+		if end < 0 {
+			continue
+		}
+
+		name := line[:end]
+		if current == nil || !strings.HasPrefix(name, current.name) {
+			current = m.get(name)
+		}
+
+		var sample string
+		if line[end] == '{' {
+			rest := renameLabel(line[end+1:], "hostname", exportedHostnameLabel)
+			if strings.HasPrefix(rest, "}") {
+				sample = name + "{" + label + rest
+			} else {
+				sample = name + "{" + label + "," + rest
+			}
+		} else {
+			sample = name + "{" + label + "}" + line[end:]
+		}
+
+		current.samples = append(current.samples, sample)
+	}
+}
+
+func (m *metricFamilies) write(w io.Writer) {
+	for _, name := range m.order {
+		family := m.byName[name]
+		if len(family.samples) == 0 {
+			continue
+		}
+
+		if family.help != "" {
+			fmt.Fprintf(w, "# HELP %s %s\n", family.name, family.help)
+		}
+
+		if family.kind != "" {
+			fmt.Fprintf(w, "# TYPE %s %s\n", family.name, family.kind)
+		}
+
+		for _, sample := range family.samples {
+			fmt.Fprintln(w, sample)
+		}
+	}
+}
+
+// renameLabel renames a label in the label set of a sample, given as the part of the sample
+// after the opening brace
+func renameLabel(labels, from, to string) string {
+	var b strings.Builder
+
+	i := 0
+	for i < len(labels) {
+		for i < len(labels) && (labels[i] == ' ' || labels[i] == ',') {
+			b.WriteByte(labels[i])
+			i++
+		}
+
+		eq := strings.IndexByte(labels[i:], '=')
+		if i >= len(labels) || labels[i] == '}' || eq < 0 {
+			break
+		}
+
+		if strings.TrimSpace(labels[i:i+eq]) == from {
+			b.WriteString(to)
+		} else {
+			b.WriteString(labels[i : i+eq])
+		}
+		i += eq
+
+		// skip the quoted value, which may contain escaped quotes, commas and braces
+		j := strings.IndexByte(labels[i:], '"')
+		if j < 0 {
+			break
+		}
+
+		j += i + 1
+		for j < len(labels) && labels[j] != '"' {
+			if labels[j] == '\\' {
+				j++
+			}
+			j++
+		}
+
+		if j < len(labels) {
+			j++
+		}
+
+		b.WriteString(labels[i:j])
+		i = j
+	}
+
+	b.WriteString(labels[i:])
+
+	return b.String()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPortAllocator(t *testing.T) {
+	allocator, err := NewPortAllocator("127.0.0.1", "41000-41001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := allocator.Allocate("a.tld", "127.0.0.1:41001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addr != "127.0.0.1:41001" {
+		t.Errorf("Expected preferred address, got %s", addr)
+	}
+
+	again, _ := allocator.Allocate("a.tld", "")
+	if again != addr {
+		t.Errorf("Expected stable address, got %s", again)
+	}
+
+	addr, err = allocator.Allocate("b.tld", "127.0.0.1:41001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addr != "127.0.0.1:41000" {
+		t.Errorf("Expected first free address, got %s", addr)
+	}
+
+	_, err = allocator.Allocate("c.tld", "")
+	if err == nil {
+		t.Error("Expected error when range is exhausted")
+	}
+
+	allocator.Release("a.tld")
+
+	addr, err = allocator.Allocate("c.tld", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addr != "127.0.0.1:41001" {
+		t.Errorf("Expected released address, got %s", addr)
+	}
+}
+
+func TestMetricFamiliesHostnameLabel(t *testing.T) {
+	body := strings.Join([]string{
+		`cloudflared_requests_total{hostname="origin.tld",path="a,hostname=\"b\""} 5`,
+		`cloudflared_requests_total{ hostname="other.tld"} 1`,
+	}, "\n")
+
+	families := newMetricFamilies()
+	families.add([]byte(body), "a.tld")
+
+	var buf bytes.Buffer
+	families.write(&buf)
+
+	expected := strings.Join([]string{
+		`cloudflared_requests_total{hostname="a.tld",exported_hostname="origin.tld",path="a,hostname=\"b\""} 5`,
+		`cloudflared_requests_total{hostname="a.tld", exported_hostname="other.tld"} 1`,
+		"",
+	}, "\n")
+
+	if buf.String() != expected {
+		t.Errorf("Unexpected output, got:\n%s", buf.String())
+	}
+}
+
+func TestNewPortAllocatorInvalid(t *testing.T) {
+	for _, portRange := range []string{"", "41000", "a-b", "42000-41000", "0-10", "65000-70000"} {
+		_, err := NewPortAllocator("127.0.0.1", portRange)
+		if err == nil {
+			t.Errorf("Expected error for %q", portRange)
+		}
+	}
+}
+
+func TestMetricFamilies(t *testing.T) {
+	body := strings.Join([]string{
+		"# HELP cloudflared_requests_total Requests.",
+		"# TYPE cloudflared_requests_total counter",
+		"cloudflared_requests_total 5",
+		"# HELP cloudflared_latency Latency.",
+		"# TYPE cloudflared_latency histogram",
+		`cloudflared_latency_bucket{le="1"} 2`,
+		"cloudflared_latency_count{} 2",
+	}, "\n")
+
+	families := newMetricFamilies()
+	families.add([]byte(body), "a.tld")
+	families.add([]byte(body), "b.tld")
+
+	var buf bytes.Buffer
+	families.write(&buf)
+
+	expected := strings.Join([]string{
+		"# HELP cloudflared_requests_total Requests.",
+		"# TYPE cloudflared_requests_total counter",
+		`cloudflared_requests_total{hostname="a.tld"} 5`,
+		`cloudflared_requests_total{hostname="b.tld"} 5`,
+		"# HELP cloudflared_latency Latency.",
+		"# TYPE cloudflared_latency histogram",
+		`cloudflared_latency_bucket{hostname="a.tld",le="1"} 2`,
+		`cloudflared_latency_count{hostname="a.tld"} 2`,
+		`cloudflared_latency_bucket{hostname="b.tld",le="1"} 2`,
+		`cloudflared_latency_count{hostname="b.tld"} 2`,
+		"",
+	}, "\n")
+
+	if buf.String() != expected {
+		t.Errorf("Unexpected output, got:\n%s", buf.String())
+	}
+}
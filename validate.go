@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/spf13/afero"
+)
+
+// ValidationResult is the outcome of a single check made by Validate. A check that passes may
+// come with a warning.
+type ValidationResult struct {
+	Subject string
+	Warning string
+	Err     error
+}
+
+// Validate checks the Hera config, the certificates and the labels of every running container
+// without resolving containers or starting tunnels
+func Validate(client *Client, fs afero.Fs) []*ValidationResult {
+	var results []*ValidationResult
+
+	_, err := LoadConfig(fs)
+	results = append(results, &ValidationResult{Subject: "config", Err: err})
+
+	err = VerifyCertificates(fs)
+	results = append(results, &ValidationResult{Subject: "certificates", Err: err})
+
+	containers, err := client.ListContainers()
+	if err != nil {
+		results = append(results, &ValidationResult{Subject: "docker", Err: err})
+		return results
+	}
+
+	seen := make(map[string]string)
+
+	for _, c := range containers {
+		container, err := client.Inspect(c.ID)
+		if err != nil {
+			results = append(results, &ValidationResult{Subject: shortID(c.ID), Err: err})
+			continue
+		}
+
+		hostname := getLabel(heraHostname, container)
+		if hostname == "" {
+			continue
+		}
+
+		result := &ValidationResult{
+			Subject: fmt.Sprintf("%s (%s)", hostname, shortID(container.ID)),
+			Err:     validateLabels(container.Config.Labels),
+		}
+
+		// two containers share a hostname while one replaces the other, which hands the
+		// tunnel over to the container that started last
+		if other, ok := seen[hostname]; ok {
+			result.Warning = fmt.Sprintf("Hostname is also used by container %s, the tunnel is handed over to the container that started last", shortID(other))
+		}
+
+		seen[hostname] = container.ID
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// validateLabels returns an error if the Hera labels of a container are invalid or no
+// certificate exists for its hostname
+func validateLabels(labels map[string]string) error {
+	hostname := labels[heraHostname]
+
+	config := &TunnelConfig{}
+	err := parseProtocol(labels, config)
+	if err != nil {
+		return err
+	}
+
+	port, ok := labels[heraPort]
+	if config.hasNetworkOrigin() && (!ok || port == "") {
+		return fmt.Errorf("Missing %s label", heraPort)
+	}
+
+	if port != "" {
+		number, err := strconv.Atoi(port)
+		if err != nil || number < 1 || number > 65535 {
+			return fmt.Errorf("Invalid %s label %q", heraPort, port)
+		}
+	}
+
+	_, err = parseOriginOptions(labels, config.Protocol)
+	if err != nil {
+		return err
+	}
+
+	_, err = parseCloudflaredSettings(labels)
+	if err != nil {
+		return err
+	}
+
+	_, err = parseProbe(labels)
+	if err != nil {
+		return err
+	}
+
+	_, err = getCertificate(hostname)
+
+	return err
+}
+
+// RunValidate prints the result of every check and returns the exit code, which is non-zero if
+// Docker cannot be reached or any check fails
+func RunValidate(w io.Writer) int {
+	client, err := NewClient()
+	if err != nil {
+		fmt.Fprintf(w, "Unable to connect to Docker: %s\n", err)
+		return 1
+	}
+
+	return writeValidation(w, Validate(client, afero.NewOsFs()))
+}
+
+// writeValidation prints validation results and returns the exit code, which is non-zero if
+// any check fails. Warnings do not change the exit code.
+func writeValidation(w io.Writer, results []*ValidationResult) int {
+	code := 0
+
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			fmt.Fprintf(w, "FAIL  %s: %s\n", result.Subject, result.Err)
+			code = 1
+		case result.Warning != "":
+			fmt.Fprintf(w, "WARN  %s: %s\n", result.Subject, result.Warning)
+		default:
+			fmt.Fprintf(w, "OK    %s\n", result.Subject)
+		}
+	}
+
+	return code
+}
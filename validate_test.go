@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWriteValidation(t *testing.T) {
+	results := []*ValidationResult{
+		{Subject: "config"},
+		{Subject: "site.tld (9b3d4f2a1c7e)", Warning: "Hostname is also used by container 0123456789ab"},
+	}
+
+	var buf bytes.Buffer
+	if code := writeValidation(&buf, results); code != 0 {
+		t.Errorf("Expected warnings to pass, got exit code %d", code)
+	}
+
+	expected := strings.Join([]string{
+		"OK    config",
+		"WARN  site.tld (9b3d4f2a1c7e): Hostname is also used by container 0123456789ab",
+		"",
+	}, "\n")
+
+	if buf.String() != expected {
+		t.Errorf("Unexpected output, got:\n%s", buf.String())
+	}
+
+	results = append(results, &ValidationResult{Subject: "certificates", Err: errors.New("No certificates found")})
+	if code := writeValidation(&buf, results); code != 1 {
+		t.Errorf("Expected failure to fail, got exit code %d", code)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	eventTunnelStarted      = "started"
+	eventTunnelConnected    = "connected"
+	eventTunnelStopped      = "stopped"
+	eventTunnelFailed       = "failed"
+	eventCertificateMissing = "certificate_missing"
+
+	webhookFormatGeneric = "generic"
+	webhookFormatSlack   = "slack"
+	webhookFormatDiscord = "discord"
+
+	webhookTimeout = 10 * time.Second
+)
+
+// webhookEvents are the events webhooks can be notified of
+var webhookEvents = []string{
+	eventTunnelStarted,
+	eventTunnelConnected,
+	eventTunnelStopped,
+	eventTunnelFailed,
+	eventCertificateMissing,
+}
+
+// notifier sends tunnel events to the configured webhooks. Notifications are disabled when it
+// is nil.
+var notifier *Notifier
+
+// Webhook is an endpoint notified of tunnel events
+type Webhook struct {
+	URL    string   `json:"url"`
+	Format string   `json:"format"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// validate returns an error if the webhook has no URL, an unknown format or an unknown event
+func (w *Webhook) validate() error {
+	if w.URL == "" {
+		return errors.New("Missing webhook url")
+	}
+
+	switch w.Format {
+	case "", webhookFormatGeneric, webhookFormatSlack, webhookFormatDiscord:
+	default:
+		return fmt.Errorf("Invalid webhook format %q", w.Format)
+	}
+
+	for _, event := range w.Events {
+		if !isWebhookEvent(event) {
+			return fmt.Errorf("Invalid webhook event %q", event)
+		}
+	}
+
+	return nil
+}
+
+// wants returns true if the webhook is notified of the event. Webhooks without events are
+// notified of every event.
+func (w *Webhook) wants(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String describes the webhook for logging by the scheme and host of its URL. The rest of the
+// URL is left out as it often holds the credentials of the webhook.
+func (w *Webhook) String() string {
+	parsed, err := url.Parse(w.URL)
+	if err != nil || parsed.Host == "" {
+		return "(invalid url)"
+	}
+
+	return fmt.Sprintf("%s://%s", parsed.Scheme, parsed.Host)
+}
+
+func isWebhookEvent(name string) bool {
+	for _, event := range webhookEvents {
+		if event == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TunnelEvent is a change in the lifecycle of a tunnel sent to webhooks
+type TunnelEvent struct {
+	Event       string    `json:"event"`
+	Hostname    string    `json:"hostname"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Message     string    `json:"message"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// NewTunnelEvent returns a new TunnelEvent with a message describing it
+func NewTunnelEvent(event, hostname, containerID string, err error) *TunnelEvent {
+	tunnelEvent := &TunnelEvent{
+		Event:       event,
+		Hostname:    hostname,
+		ContainerID: containerID,
+		Time:        time.Now().UTC(),
+	}
+
+	switch event {
+	case eventTunnelStarted:
+		tunnelEvent.Message = fmt.Sprintf("Tunnel %s has started", hostname)
+	case eventTunnelConnected:
+		tunnelEvent.Message = fmt.Sprintf("Tunnel %s is connected", hostname)
+	case eventTunnelStopped:
+		tunnelEvent.Message = fmt.Sprintf("Tunnel %s has stopped", hostname)
+	case eventTunnelFailed:
+		tunnelEvent.Message = fmt.Sprintf("Tunnel %s has failed", hostname)
+	case eventCertificateMissing:
+		tunnelEvent.Message = fmt.Sprintf("No certificate found for %s", hostname)
+	}
+
+	if err != nil {
+		tunnelEvent.Error = err.Error()
+		tunnelEvent.Message = fmt.Sprintf("%s: %s", tunnelEvent.Message, err)
+	}
+
+	return tunnelEvent
+}
+
+// payload returns the body sent to a webhook in its format
+func (e *TunnelEvent) payload(format string) ([]byte, error) {
+	switch format {
+	case webhookFormatSlack:
+		return json.Marshal(map[string]string{"text": e.Message})
+	case webhookFormatDiscord:
+		return json.Marshal(map[string]string{"content": e.Message})
+	}
+
+	return json.Marshal(e)
+}
+
+// Notifier sends tunnel events to webhooks in the background, retrying failed deliveries with
+// an increasing delay
+type Notifier struct {
+	Webhooks []*Webhook
+	Retries  int
+	Backoff  time.Duration
+
+	client  *http.Client
+	pending sync.WaitGroup
+}
+
+// NewNotifier returns a new Notifier.
+// An error is returned if a webhook is invalid.
+func NewNotifier(webhooks []*Webhook, retries int, backoff time.Duration) (*Notifier, error) {
+	for _, webhook := range webhooks {
+		err := webhook.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	n := &Notifier{
+		Webhooks: webhooks,
+		Retries:  retries,
+		Backoff:  backoff,
+		client:   &http.Client{Timeout: webhookTimeout},
+	}
+
+	return n, nil
+}
+
+// Notify sends an event to every webhook that wants it
+func (n *Notifier) Notify(event *TunnelEvent) {
+	for _, webhook := range n.Webhooks {
+		if !webhook.wants(event.Event) {
+			continue
+		}
+
+		n.pending.Add(1)
+		go func(webhook *Webhook) {
+			defer n.pending.Done()
+
+			err := n.deliver(webhook, event)
+			if err != nil {
+				log.Errorf("Unable to send %s event for %s to webhook %s: %s", event.Event, event.Hostname, webhook, err)
+			}
+		}(webhook)
+	}
+}
+
+// Wait waits up to timeout for pending deliveries to finish. It returns false if deliveries
+// were still pending.
+func (n *Notifier) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		n.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// deliver sends an event to a webhook, retrying on failure
+func (n *Notifier) deliver(webhook *Webhook, event *TunnelEvent) error {
+	body, err := event.payload(webhook.Format)
+	if err != nil {
+		return err
+	}
+
+	backoff := n.Backoff
+
+	for attempt := 0; ; attempt++ {
+		err = n.send(webhook, event.Event, body)
+		if err == nil || attempt >= n.Retries {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// send posts a payload to a webhook, signing it if the webhook has a secret. Errors leave out
+// the URL of the webhook.
+func (n *Notifier) send(webhook *Webhook, event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return withoutURL(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Hera/"+CurrentVersion)
+	req.Header.Set("X-Hera-Event", event)
+
+	if webhook.Secret != "" {
+		req.Header.Set("X-Hera-Signature", "sha256="+signPayload(webhook.Secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return withoutURL(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected response %s", resp.Status)
+	}
+
+	return nil
+}
+
+// withoutURL returns the cause of an error from the HTTP client, which would otherwise hold the
+// full URL of the request
+func withoutURL(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return fmt.Errorf("%s: %s", urlErr.Op, urlErr.Err)
+	}
+
+	return err
+}
+
+// signPayload returns the hex encoded HMAC-SHA256 of body using secret as the key
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notify sends an event for a tunnel to the webhooks if notifications are enabled
+func notify(event string, config *TunnelConfig, err error) {
+	if notifier == nil {
+		return
+	}
+
+	notifier.Notify(NewTunnelEvent(event, config.Hostname, config.ContainerID, err))
+}
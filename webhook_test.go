@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotifierDelivery(t *testing.T) {
+	var mu sync.Mutex
+	var requests []*http.Request
+	var bodies [][]byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	webhook := &Webhook{URL: server.URL, Events: []string{eventTunnelFailed}, Secret: "secret"}
+
+	n, err := NewNotifier([]*Webhook{webhook}, 2, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.Notify(NewTunnelEvent(eventTunnelStarted, "site.tld", "abc", nil))
+	n.Notify(NewTunnelEvent(eventTunnelFailed, "site.tld", "abc", errors.New("exit code 1")))
+
+	if !n.Wait(5 * time.Second) {
+		t.Fatal("Timed out waiting for webhooks")
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("Expected one retried delivery, got %d requests", len(requests))
+	}
+
+	var event TunnelEvent
+	err = json.Unmarshal(bodies[1], &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.Event != eventTunnelFailed || event.Error != "exit code 1" || event.Message != "Tunnel site.tld has failed: exit code 1" {
+		t.Errorf("Unexpected event, got %+v", event)
+	}
+
+	signature := requests[1].Header.Get("X-Hera-Signature")
+	if signature != "sha256="+signPayload("secret", bodies[1]) {
+		t.Errorf("Unexpected signature, got %s", signature)
+	}
+
+	if requests[1].Header.Get("X-Hera-Event") != eventTunnelFailed {
+		t.Errorf("Unexpected event header, got %s", requests[1].Header.Get("X-Hera-Event"))
+	}
+}
+
+func TestTunnelEventPayload(t *testing.T) {
+	event := NewTunnelEvent(eventTunnelStopped, "site.tld", "", nil)
+
+	tests := map[string]string{
+		webhookFormatSlack:   `{"text":"Tunnel site.tld has stopped"}`,
+		webhookFormatDiscord: `{"content":"Tunnel site.tld has stopped"}`,
+	}
+
+	for format, expected := range tests {
+		payload, err := event.payload(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(payload) != expected {
+			t.Errorf("Unexpected %s payload, got %s", format, payload)
+		}
+	}
+}
+
+func TestSignPayload(t *testing.T) {
+	signature := signPayload("key", []byte("The quick brown fox jumps over the lazy dog"))
+
+	if signature != "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8" {
+		t.Errorf("Unexpected signature, got %s", signature)
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	invalid := []*Webhook{
+		{},
+		{URL: "http://hooks.local", Format: "teams"},
+		{URL: "http://hooks.local", Events: []string{"exploded"}},
+	}
+
+	for _, webhook := range invalid {
+		if webhook.validate() == nil {
+			t.Errorf("Expected error for webhook %+v", webhook)
+		}
+	}
+
+	valid := &Webhook{URL: "http://hooks.local", Format: webhookFormatSlack, Events: []string{eventCertificateMissing}}
+	if err := valid.validate(); err != nil {
+		t.Errorf("Unexpected error, got %s", err)
+	}
+}
+
+func TestWebhookString(t *testing.T) {
+	tests := map[string]string{
+		"https://hooks.slack.com/services/T000/B000/XXXXXXXX":  "https://hooks.slack.com",
+		"https://discord.com/api/webhooks/123/token?wait=true": "https://discord.com",
+		"://hooks.local": "(invalid url)",
+	}
+
+	for url, expected := range tests {
+		webhook := &Webhook{URL: url}
+		if webhook.String() != expected {
+			t.Errorf("Unexpected description of %s, got %s", url, webhook.String())
+		}
+	}
+}
+
+func TestSendLeavesOutURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	webhook := &Webhook{URL: server.URL + "/services/secret-token"}
+
+	n, err := NewNotifier([]*Webhook{webhook}, 0, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.send(webhook, eventTunnelStarted, []byte("{}"))
+	if err == nil {
+		t.Fatal("Expected error for closed server")
+	}
+
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("Expected error without the webhook url, got %s", err)
+	}
+}